@@ -2,16 +2,17 @@ package access
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"sync"
 	"time"
 
-	log "github.com/cihub/seelog"
 	"github.com/jinzhu/gorm"
 	httpr "github.com/julienschmidt/httprouter"
 	"github.com/ok-borg/api/ctxext"
 	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/metrics"
+	"github.com/ok-borg/api/reqlog"
+	"github.com/ok-borg/api/v"
 )
 
 type AccessKinds int
@@ -21,9 +22,9 @@ type UserAccess struct {
 	Create int
 }
 
-// FIXME(jeremy): should be in config
-// maximum access for write and updates in 24 hours
-const (
+// maximum access for write and updates in 24 hours,
+// overwritten from the configuration by Init
+var (
 	maxCreate = 100
 	maxUpdate = 50
 )
@@ -44,6 +45,11 @@ func init() {
 	accessControl = map[string]UserAccess{}
 }
 
+func Init(createLimit int, updateLimit int) {
+	maxCreate = createLimit
+	maxUpdate = updateLimit
+}
+
 func updateTimer() {
 	mtx.Lock()
 	if time.Since(lastAccessControlReset) >= (time.Hour * 24) {
@@ -66,7 +72,9 @@ func Control(handler func(ctx context.Context, w http.ResponseWriter, r *http.Re
 				accessControl[token] = newAc
 			} else {
 				if ac.Create >= maxCreate {
-					writeResponse(w, http.StatusUnauthorized, "borg-api: api max create reached")
+					mtx.Unlock()
+					metrics.AccessRejected("create")
+					common.WriteErrorf(ctx, w, domain.ErrRateLimited, "borg-api: api max create reached")
 					return
 				}
 				ac.Create += 1
@@ -78,22 +86,74 @@ func Control(handler func(ctx context.Context, w http.ResponseWriter, r *http.Re
 				newAc := UserAccess{Update: 1}
 				accessControl[token] = newAc
 			} else {
-				if ac.Create >= maxUpdate {
-					writeResponse(w, http.StatusUnauthorized, "borg-api: api max update reached")
+				if ac.Update >= maxUpdate {
+					mtx.Unlock()
+					metrics.AccessRejected("update")
+					common.WriteErrorf(ctx, w, domain.ErrRateLimited, "borg-api: api max update reached")
 					return
 				}
-				ac.Create += 1
+				ac.Update += 1
 				accessControl[token] = ac
 			}
 		}
-		// just log some shit
-		log.Infof("[user access control] token: %s -> %#v", token, accessControl[token])
+		// just log some shit, never the token itself
+		userId, _ := ctxext.UserId(ctx)
+		reqlog.Debugf(ctx, "[user access control] user: %s -> %#v", userId, accessControl[token])
 		mtx.Unlock()
 		// then call the handler
 		handler(ctx, w, r, p)
 	}
 }
 
+// RequireScope rejects the requests made with a token lacking scope,
+// anonymous requests of MaybeAuth endpoints are let through
+func RequireScope(scope string, handler func(ctx context.Context, w http.ResponseWriter, r *http.Request, p httpr.Params)) func(ctx context.Context, w http.ResponseWriter, r *http.Request, p httpr.Params) {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request, p httpr.Params) {
+		if isAuth, _ := ctxext.IsAuth(ctx); isAuth {
+			if at, ok := ctxext.AccessToken(ctx); !ok || !at.Scopes.Has(scope) {
+				common.WriteErrorf(ctx, w, domain.ErrForbidden, "borg-api: access token lacks the %s scope", scope)
+				return
+			}
+		}
+		handler(ctx, w, r, p)
+	}
+}
+
+// tokens last used less than this ago are not updated again
+const lastUsedPrecision = time.Minute
+
+// authenticate validates a borg access token and returns a context holding the user
+func authenticate(db *gorm.DB, r *http.Request, token string) (context.Context, error) {
+	accessTokenDao := domain.NewAccessTokenDao(db)
+	at, err := accessTokenDao.GetByToken(token)
+	if err != nil {
+		return nil, domain.Unauthorizedf("borg-api: Invalid access token")
+	}
+	if at.IsExpired() {
+		return nil, domain.Unauthorizedf("borg-api: Expired access token")
+	}
+	// get or create it in mysql
+	userDao := domain.NewUserDao(db)
+	user, err := userDao.GetById(at.UserId)
+	if err != nil {
+		return nil, domain.Unauthorizedf("borg-api: Invalid access token")
+	}
+	if at.LastUsedAt == nil || time.Since(*at.LastUsedAt) > lastUsedPrecision {
+		now := time.Now()
+		if err := accessTokenDao.UpdateLastUsed(at.Id, now); err != nil {
+			reqlog.Warnf(r.Context(), "[authenticate] unable to update token last use: %s", err.Error())
+		}
+		at.LastUsedAt = &now
+	}
+
+	ctx := ctxext.WithTokenString(r.Context(), token)
+	ctx = ctxext.WithAccessToken(ctx, at)
+	ctx = ctxext.WithUserId(ctx, user.Id)
+	ctx = ctxext.WithUser(ctx, user)
+	ctx = ctxext.WithIsAuth(ctx, true)
+	return ctx, nil
+}
+
 // simple helper to check if the user is auth in the application,
 // if logged process the handler, or return directly
 func IfAuth(db *gorm.DB, handler func(ctx context.Context, w http.ResponseWriter, r *http.Request, p httpr.Params)) func(w http.ResponseWriter, r *http.Request, p httpr.Params) {
@@ -102,31 +162,18 @@ func IfAuth(db *gorm.DB, handler func(ctx context.Context, w http.ResponseWriter
 		if token = r.FormValue("token"); token == "" {
 			if token = r.Header.Get("Authorization"); token == "" {
 				if token = r.Header.Get("authorization"); token == "" {
-					writeResponse(w, http.StatusUnauthorized, "borg-api: Missing access token")
+					common.WriteErrorf(r.Context(), w, domain.ErrUnauthorized, "borg-api: Missing access token")
 					return
 				}
 			}
 		}
 
-		accessTokenDao := domain.NewAccessTokenDao(db)
-		at, err := accessTokenDao.GetByToken(token)
-		if err != nil {
-			writeResponse(w, http.StatusUnauthorized, "borg-api: Invalid access token")
-			return
-		}
-		// get or create it in mysql
-		userDao := domain.NewUserDao(db)
-		user, err := userDao.GetById(at.UserId)
+		ctx, err := authenticate(db, r, token)
 		if err != nil {
-			writeResponse(w, http.StatusUnauthorized, "borg-api: Invalid access token")
+			common.WriteError(r.Context(), w, err)
 			return
 		}
-
 		// no errors, process the handler
-		ctx := ctxext.WithTokenString(context.Background(), token)
-		ctx = ctxext.WithUserId(ctx, user.Id)
-		ctx = ctxext.WithUser(ctx, user)
-		ctx = ctxext.WithIsAuth(ctx, true)
 		handler(ctx, w, r, p)
 	}
 }
@@ -140,40 +187,19 @@ func MaybeAuth(db *gorm.DB, handler func(ctx context.Context, w http.ResponseWri
 			if token = r.Header.Get("Authorization"); token == "" {
 				if token = r.Header.Get("authorization"); token == "" {
 					// no token just add IsAuth value in the ctx and call the handler
-					ctx := ctxext.WithIsAuth(context.Background(), false)
+					ctx := ctxext.WithIsAuth(r.Context(), false)
 					handler(ctx, w, r, p)
 				}
 			}
 		}
 		if len(token) > 0 {
-			accessTokenDao := domain.NewAccessTokenDao(db)
-			at, err := accessTokenDao.GetByToken(token)
-			if err != nil {
-				writeResponse(w, http.StatusUnauthorized, "borg-api: Invalid access token")
-				return
-			}
-			// get or create it in mysql
-			userDao := domain.NewUserDao(db)
-			user, err := userDao.GetById(at.UserId)
+			ctx, err := authenticate(db, r, token)
 			if err != nil {
-				writeResponse(w, http.StatusUnauthorized, "borg-api: Invalid access token")
+				common.WriteError(r.Context(), w, err)
 				return
 			}
-
 			// no errors, process the handler
-			ctx := ctxext.WithTokenString(context.Background(), token)
-			ctx = ctxext.WithUserId(ctx, user.Id)
-			ctx = ctxext.WithUser(ctx, user)
-			ctx = ctxext.WithIsAuth(ctx, true)
 			handler(ctx, w, r, p)
 		}
 	}
 }
-
-// FIXME: this is duplicated in main.go
-func writeResponse(w http.ResponseWriter, status int, body string) {
-	w.Header().Set("Content-Length", fmt.Sprintf("%v", len(body)))
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	fmt.Fprintf(w, `%v`, body)
-}
@@ -1,5 +1,26 @@
 package conf
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const redacted = "********"
+
+const (
+	LogFormatText = "text"
+	LogFormatJson = "json"
+)
+
+var logLevels = []string{"trace", "debug", "info", "warn", "error", "critical"}
+
 type Mysql struct {
 	Addr string `json:"addr"`
 	Ids  string `json:"ids"`
@@ -8,12 +29,307 @@ type Mysql struct {
 type Github struct {
 	ClientId     string `json:"client_id"`
 	ClientSecret string `json:"client_secret"`
+	RedirectUrl  string `json:"redirect_url"`
+	ApiUrl       string `json:"api_url"` // https://api.github.com/ when empty
+	// hours between two syncs of the organizations linked to github ones, 0 disables it
+	OrgSyncInterval int `json:"org_sync_interval"`
+}
+
+// Gitlab login is enabled when the client id is set
+type Gitlab struct {
+	BaseUrl      string `json:"base_url"` // gitlab.com when empty
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RedirectUrl  string `json:"redirect_url"`
+}
+
+// Oidc login is enabled when the issuer is set
+type Oidc struct {
+	Issuer       string `json:"issuer"`
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RedirectUrl  string `json:"redirect_url"`
+}
+
+// RateLimit is the maximum number of writes a token can do in 24 hours
+type RateLimit struct {
+	MaxCreate int `json:"max_create"`
+	MaxUpdate int `json:"max_update"`
+}
+
+type Auth struct {
+	TokenTtl  int    `json:"token_ttl"`  // hours before an access token expires
+	SecretKey string `json:"secret_key"` // hex encoded 32 bytes key encrypting secrets stored in mysql
+	// redirect urls a client may ask for instead of the one of the provider
+	RedirectUrls []string `json:"redirect_urls"`
+	// hours between two profile syncs of the active users, 0 disables it
+	ProfileSyncInterval int `json:"profile_sync_interval"`
+}
+
+// Mail is sent through the smtp server when its address is set,
+// otherwise written as files in Dir
+type Mail struct {
+	SmtpAddr string `json:"smtp_addr"` // host:port
+	From     string `json:"from"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Dir      string `json:"dir"`
+}
+
+type Organizations struct {
+	// hours a deleted organization can be restored before being purged
+	DeletionGracePeriod int `json:"deletion_grace_period"`
+}
+
+type Log struct {
+	Format string `json:"format"` // text or json
+	Level  string `json:"level"`
 }
 
 type Conf struct {
-	EsAddr    string `json:"esaddr"`
-	Github    Github `json:"github"`
-	Sitemap   string `json:"sitemap"`
-	Analytics string `json:"analytics"`
-	Mysql     Mysql  `json:"mysql"`
+	EsAddr    string    `json:"esaddr"`
+	Github    Github    `json:"github"`
+	Gitlab    Gitlab    `json:"gitlab"`
+	Oidc      Oidc      `json:"oidc"`
+	Sitemap   string    `json:"sitemap"`
+	Analytics string    `json:"analytics"`
+	Mysql     Mysql     `json:"mysql"`
+	Port      int       `json:"port"`
+	RateLimit RateLimit `json:"rate_limit"`
+	Log       Log       `json:"log"`
+	Auth      Auth      `json:"auth"`
+
+	Organizations Organizations `json:"organizations"`
+	Mail          Mail          `json:"mail"`
+}
+
+// Default returns the configuration used when nothing else is set
+func Default() Conf {
+	return Conf{
+		EsAddr: "127.0.0.1:9200",
+		Mysql: Mysql{
+			Addr: "127.0.0.1:3306",
+			Ids:  "root:root",
+		},
+		Port: 9992,
+		RateLimit: RateLimit{
+			MaxCreate: 100,
+			MaxUpdate: 50,
+		},
+		Log: Log{
+			Format: LogFormatText,
+			Level:  "info",
+		},
+		Auth: Auth{
+			TokenTtl:            24 * 30,
+			ProfileSyncInterval: 24,
+		},
+		Github: Github{
+			OrgSyncInterval: 6,
+		},
+		Organizations: Organizations{
+			DeletionGracePeriod: 24 * 7,
+		},
+		Mail: Mail{
+			Dir: filepath.Join(os.TempDir(), "borg-mail"),
+		},
+	}
+}
+
+// LoadFile overrides the configuration with the values present in the json file at path.
+// A missing file is not an error, there is probably no configuration file.
+func (c *Conf) LoadFile(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to read config file %s: %s", path, err.Error())
+	}
+	// unmarshal on top of the current values so keys missing from the file are kept
+	if err := json.Unmarshal(raw, c); err != nil {
+		return fmt.Errorf("invalid config format in %s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// LoadEnv overrides the configuration with the BORG_* environment variables which are set
+func (c *Conf) LoadEnv() error {
+	envString("BORG_ESADDR", &c.EsAddr)
+	envString("BORG_GITHUB_CLIENT_ID", &c.Github.ClientId)
+	envString("BORG_GITHUB_CLIENT_SECRET", &c.Github.ClientSecret)
+	envString("BORG_GITHUB_REDIRECT_URL", &c.Github.RedirectUrl)
+	envString("BORG_GITHUB_API_URL", &c.Github.ApiUrl)
+	envString("BORG_GITLAB_BASE_URL", &c.Gitlab.BaseUrl)
+	envString("BORG_GITLAB_CLIENT_ID", &c.Gitlab.ClientId)
+	envString("BORG_GITLAB_CLIENT_SECRET", &c.Gitlab.ClientSecret)
+	envString("BORG_GITLAB_REDIRECT_URL", &c.Gitlab.RedirectUrl)
+	envString("BORG_OIDC_ISSUER", &c.Oidc.Issuer)
+	envString("BORG_OIDC_CLIENT_ID", &c.Oidc.ClientId)
+	envString("BORG_OIDC_CLIENT_SECRET", &c.Oidc.ClientSecret)
+	envString("BORG_OIDC_REDIRECT_URL", &c.Oidc.RedirectUrl)
+	envString("BORG_SITEMAP", &c.Sitemap)
+	envString("BORG_ANALYTICS", &c.Analytics)
+	envString("BORG_MAIL_SMTP_ADDR", &c.Mail.SmtpAddr)
+	envString("BORG_MAIL_FROM", &c.Mail.From)
+	envString("BORG_MAIL_USERNAME", &c.Mail.Username)
+	envString("BORG_MAIL_PASSWORD", &c.Mail.Password)
+	envString("BORG_MAIL_DIR", &c.Mail.Dir)
+	envString("BORG_MYSQL_ADDR", &c.Mysql.Addr)
+	envString("BORG_MYSQL_IDS", &c.Mysql.Ids)
+	envString("BORG_LOG_FORMAT", &c.Log.Format)
+	envString("BORG_LOG_LEVEL", &c.Log.Level)
+	envString("BORG_AUTH_SECRET_KEY", &c.Auth.SecretKey)
+	envStrings("BORG_AUTH_REDIRECT_URLS", &c.Auth.RedirectUrls)
+	if err := envInt("BORG_AUTH_TOKEN_TTL", &c.Auth.TokenTtl); err != nil {
+		return err
+	}
+	if err := envInt("BORG_AUTH_PROFILE_SYNC_INTERVAL", &c.Auth.ProfileSyncInterval); err != nil {
+		return err
+	}
+	if err := envInt("BORG_GITHUB_ORG_SYNC_INTERVAL", &c.Github.OrgSyncInterval); err != nil {
+		return err
+	}
+	if err := envInt("BORG_ORGANIZATIONS_DELETION_GRACE_PERIOD", &c.Organizations.DeletionGracePeriod); err != nil {
+		return err
+	}
+	if err := envInt("BORG_PORT", &c.Port); err != nil {
+		return err
+	}
+	if err := envInt("BORG_RATE_LIMIT_MAX_CREATE", &c.RateLimit.MaxCreate); err != nil {
+		return err
+	}
+	return envInt("BORG_RATE_LIMIT_MAX_UPDATE", &c.RateLimit.MaxUpdate)
+}
+
+// Validate returns an error describing every invalid setting
+func (c Conf) Validate() error {
+	errs := []string{}
+	if c.EsAddr == "" {
+		errs = append(errs, "esaddr is empty")
+	}
+	if c.Mysql.Addr == "" {
+		errs = append(errs, "mysql.addr is empty")
+	}
+	if c.Mysql.Ids == "" {
+		errs = append(errs, "mysql.ids is empty")
+	}
+	if (c.Github.ClientId == "") != (c.Github.ClientSecret == "") {
+		errs = append(errs, "github.client_id and github.client_secret must be set together")
+	}
+	if c.Gitlab.ClientId != "" && (c.Gitlab.ClientSecret == "" || c.Gitlab.RedirectUrl == "") {
+		errs = append(errs, "gitlab.client_secret and gitlab.redirect_url are required with gitlab.client_id")
+	}
+	if c.Oidc.Issuer != "" && (c.Oidc.ClientId == "" || c.Oidc.RedirectUrl == "") {
+		errs = append(errs, "oidc.client_id and oidc.redirect_url are required with oidc.issuer")
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("port %d is out of range", c.Port))
+	}
+	if c.RateLimit.MaxCreate <= 0 {
+		errs = append(errs, "rate_limit.max_create must be positive")
+	}
+	if c.RateLimit.MaxUpdate <= 0 {
+		errs = append(errs, "rate_limit.max_update must be positive")
+	}
+	if c.Log.Format != LogFormatText && c.Log.Format != LogFormatJson {
+		errs = append(errs, fmt.Sprintf("log.format %q must be text or json", c.Log.Format))
+	}
+	if !contains(logLevels, c.Log.Level) {
+		errs = append(errs, fmt.Sprintf("log.level %q must be one of %s", c.Log.Level, strings.Join(logLevels, ", ")))
+	}
+	if c.Auth.TokenTtl <= 0 {
+		errs = append(errs, "auth.token_ttl must be positive")
+	}
+	if c.Auth.ProfileSyncInterval < 0 {
+		errs = append(errs, "auth.profile_sync_interval cannot be negative")
+	}
+	if c.Github.OrgSyncInterval < 0 {
+		errs = append(errs, "github.org_sync_interval cannot be negative")
+	}
+	if c.Github.ApiUrl != "" {
+		if parsed, err := url.Parse(c.Github.ApiUrl); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			errs = append(errs, fmt.Sprintf("github.api_url %q must be an http or https url", c.Github.ApiUrl))
+		}
+	}
+	if c.Organizations.DeletionGracePeriod < 0 {
+		errs = append(errs, "organizations.deletion_grace_period cannot be negative")
+	}
+	if c.Mail.SmtpAddr != "" && c.Mail.From == "" {
+		errs = append(errs, "mail.from is required to send mails through mail.smtp_addr")
+	}
+	if c.Mail.SmtpAddr == "" && c.Mail.Dir == "" {
+		errs = append(errs, "mail.dir is required when mail.smtp_addr is not set")
+	}
+	if len(c.Auth.SecretKey) != 64 {
+		errs = append(errs, "auth.secret_key must be 64 hex characters")
+	}
+	for _, u := range c.Auth.RedirectUrls {
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			errs = append(errs, fmt.Sprintf("auth.redirect_urls %q must be an http or https url", u))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration safe to be printed
+func (c Conf) Redacted() Conf {
+	if c.Github.ClientSecret != "" {
+		c.Github.ClientSecret = redacted
+	}
+	if c.Gitlab.ClientSecret != "" {
+		c.Gitlab.ClientSecret = redacted
+	}
+	if c.Oidc.ClientSecret != "" {
+		c.Oidc.ClientSecret = redacted
+	}
+	if c.Auth.SecretKey != "" {
+		c.Auth.SecretKey = redacted
+	}
+	if c.Mail.Password != "" {
+		c.Mail.Password = redacted
+	}
+	// ids are user:password, keep the user around
+	if i := strings.Index(c.Mysql.Ids, ":"); i >= 0 {
+		c.Mysql.Ids = c.Mysql.Ids[:i+1] + redacted
+	}
+	return c
+}
+
+func envString(key string, dst *string) {
+	if v, ok := os.LookupEnv(key); ok {
+		*dst = v
+	}
+}
+
+// envStrings reads a comma separated list
+func envStrings(key string, dst *[]string) {
+	if v, ok := os.LookupEnv(key); ok {
+		*dst = strings.Split(v, ",")
+	}
+}
+
+func envInt(key string, dst *int) error {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %s", key, err.Error())
+	}
+	*dst = i
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
@@ -1,19 +1,65 @@
 package conf
 
-import log "github.com/cihub/seelog"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/cihub/seelog"
+)
 
 func init() {
-	logger, _ := log.LoggerFromConfigAsString(seelogConf)
+	log.RegisterCustomFormatter("JsonLine", newJsonLine)
+	logger, _ := log.LoggerFromConfigAsString(seelogConfig(Default().Log))
 	log.ReplaceLogger(logger)
 }
 
+// InitLogger replaces the default logger with one using the configured format and level
+func InitLogger(c Log) error {
+	logger, err := log.LoggerFromConfigAsString(seelogConfig(c))
+	if err != nil {
+		return fmt.Errorf("unable to initialize logger: %s", err.Error())
+	}
+	return log.ReplaceLogger(logger)
+}
+
+func seelogConfig(c Log) string {
+	formatId := "colored"
+	if c.Format == LogFormatJson {
+		formatId = "json"
+	}
+	return strings.NewReplacer("{{level}}", c.Level, "{{format}}", formatId).Replace(seelogConf)
+}
+
+// newJsonLine creates the %JsonLine formatter, it writes each message as a json object.
+// Messages which already are json objects (see the reqlog package) are merged in the line.
+func newJsonLine(params string) log.FormatterFunc {
+	return func(message string, level log.LogLevel, context log.LogContextInterface) interface{} {
+		fields := map[string]interface{}{}
+		if !strings.HasPrefix(message, "{") || json.Unmarshal([]byte(message), &fields) != nil {
+			fields = map[string]interface{}{"msg": message}
+		}
+		fields["time"] = context.CallTime().Format("2006-01-02T15:04:05.000Z07:00")
+		fields["level"] = level.String()
+		if _, ok := fields["file"]; !ok {
+			fields["file"] = fmt.Sprintf("%s:%d", context.FileName(), context.Line())
+		}
+		bs, err := json.Marshal(fields)
+		if err != nil {
+			return message
+		}
+		return string(bs)
+	}
+}
+
 const seelogConf = `
-<seelog>
+<seelog minlevel="{{level}}">
   <outputs>
-    <console formatid="colored"/>
+    <console formatid="{{format}}"/>
   </outputs>
   <formats>
     <format id="colored"  format="%Date(2006 Jan 02/3:04:05.00 PM MST) (%File) [%EscM(36)%LEVEL%EscM(39)] %Msg%n%EscM(0)"/>
+    <format id="json" format="%JsonLine%n"/>
   </formats>
 </seelog>
 `
@@ -12,6 +12,7 @@ const (
 	userKey        = "domain.User"
 	userIdKey      = "userId"
 	isAuth         = "isAuth"
+	requestIdKey   = "requestId"
 )
 
 func IsAuth(ctx context.Context) (bool, bool) {
@@ -39,6 +40,11 @@ func User(ctx context.Context) (domain.User, bool) {
 	return u, ok
 }
 
+func RequestId(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIdKey).(string)
+	return id, ok
+}
+
 func WithTokenString(ctx context.Context, at string) context.Context {
 	return context.WithValue(ctx, tokenStringKey, at)
 }
@@ -58,3 +64,7 @@ func WithUserId(ctx context.Context, userId string) context.Context {
 func WithIsAuth(ctx context.Context, auth bool) context.Context {
 	return context.WithValue(ctx, isAuth, auth)
 }
+
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey, id)
+}
@@ -1,6 +1,11 @@
 package domain
 
-import "github.com/jinzhu/gorm"
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ok-borg/api/secrets"
+)
 
 type AccessTokenDao struct {
 	db *gorm.DB
@@ -14,18 +19,66 @@ func (at *AccessTokenDao) Create(model AccessToken) error {
 	return at.db.Create(&model).Error
 }
 
+// GetByToken finds a token from its clear value
 func (at *AccessTokenDao) GetByToken(token string) (AccessToken, error) {
 	model := AccessToken{}
-	err := at.db.Where("access_tokens.token = ?", token).
+	err := at.db.Where("access_tokens.token_hash = ?", secrets.HashToken(token)).
 		First(&model).Error
 	return model, err
 }
 
+func (at *AccessTokenDao) GetById(id string) (AccessToken, error) {
+	model := AccessToken{Id: id}
+	err := at.db.First(&model).Error
+	return model, err
+}
+
+// ListByUser returns every token of a user, sessions included
+func (at *AccessTokenDao) ListByUser(userId string) ([]AccessToken, error) {
+	models := []AccessToken{}
+	err := at.db.Where("access_tokens.user_id = ?", userId).
+		Order("access_tokens.created_at desc").
+		Find(&models).Error
+	return models, err
+}
+
+// ListPersonalByUser returns the personal tokens of a user, most recent first
+func (at *AccessTokenDao) ListPersonalByUser(userId string) ([]AccessToken, error) {
+	models := []AccessToken{}
+	err := at.db.Where("access_tokens.user_id = ? AND access_tokens.is_personal = 1", userId).
+		Order("access_tokens.created_at desc").
+		Find(&models).Error
+	return models, err
+}
+
+// ListActiveUserIds returns the users having used or received a token since the given time
+func (at *AccessTokenDao) ListActiveUserIds(since time.Time) ([]string, error) {
+	ids := []string{}
+	err := at.db.Model(&AccessToken{}).
+		Where("access_tokens.last_used_at > ? OR access_tokens.created_at > ?", since, since).
+		Pluck("DISTINCT access_tokens.user_id", &ids).Error
+	return ids, err
+}
+
+func (at *AccessTokenDao) Update(model AccessToken) error {
+	return at.db.Save(&model).Error
+}
+
+func (at *AccessTokenDao) UpdateLastUsed(id string, lastUsed time.Time) error {
+	return at.db.Model(&AccessToken{Id: id}).
+		UpdateColumn("last_used_at", lastUsed).Error
+}
+
 func (at *AccessTokenDao) Delete(id string) error {
 	return at.db.Delete(&AccessToken{Id: id}).Error
 }
 
+func (at *AccessTokenDao) DeleteByUser(userId string) error {
+	return at.db.Where("access_tokens.user_id = ?", userId).
+		Delete(&AccessToken{}).Error
+}
+
 func (at *AccessTokenDao) DeleteByToken(token string) error {
-	return at.db.Where("access_tokens.token = ?", token).
+	return at.db.Where("access_tokens.token_hash = ?", secrets.HashToken(token)).
 		Delete(&AccessToken{}).Error
 }
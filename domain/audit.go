@@ -0,0 +1,44 @@
+package domain
+
+import "time"
+
+// actions recorded in the audit log of an organization
+const (
+	AuditMemberJoin       = "member.join"
+	AuditMemberLeave      = "member.leave"
+	AuditMemberExpel      = "member.expel"
+	AuditMemberRole       = "member.role"
+	AuditJoinLinkCreate   = "join_link.create"
+	AuditJoinLinkRevoke   = "join_link.revoke"
+	AuditInvitationCreate = "invitation.create"
+	AuditOrgRename        = "organization.rename"
+	AuditOrgTransfer      = "organization.transfer"
+	AuditOrgDelete        = "organization.delete"
+	AuditOrgRestore       = "organization.restore"
+	AuditOrgGithubLink    = "organization.github_link"
+	AuditOrgGithubUnlink  = "organization.github_unlink"
+	AuditSnippetCreate    = "snippet.create"
+	AuditSnippetUpdate    = "snippet.update"
+	AuditSnippetDelete    = "snippet.delete"
+)
+
+// AuditEvent is something done by a user in an organization
+type AuditEvent struct {
+	Id             string
+	OrganizationId string
+	ActorId        string
+	Action         string
+	// TargetId is the user, join link, invitation or snippet acted on, if any
+	TargetId  string `json:",omitempty"`
+	Details   string `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+// AuditFilter selects the events of an organization, empty fields match everything
+type AuditFilter struct {
+	OrganizationId string
+	ActorId        string
+	Action         string
+	Since          *time.Time
+	Until          *time.Time
+}
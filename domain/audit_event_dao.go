@@ -0,0 +1,53 @@
+package domain
+
+import "github.com/jinzhu/gorm"
+
+type AuditEventDao struct {
+	db *gorm.DB
+}
+
+func NewAuditEventDao(db *gorm.DB) *AuditEventDao {
+	return &AuditEventDao{db: db}
+}
+
+func (ad *AuditEventDao) Create(e AuditEvent) error {
+	return ad.db.Create(&e).Error
+}
+
+// List returns a page of the events matching the filter, latest first, and their total
+func (ad *AuditEventDao) List(f AuditFilter, offset int, limit int) ([]AuditEvent, int64, error) {
+	q := ad.db.Model(&AuditEvent{}).
+		Where("audit_events.organization_id = ?", f.OrganizationId)
+	if f.ActorId != "" {
+		q = q.Where("audit_events.actor_id = ?", f.ActorId)
+	}
+	if f.Action != "" {
+		q = q.Where("audit_events.action = ?", f.Action)
+	}
+	if f.Since != nil {
+		q = q.Where("audit_events.created_at >= ?", *f.Since)
+	}
+	if f.Until != nil {
+		q = q.Where("audit_events.created_at < ?", *f.Until)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	es := []AuditEvent{}
+	err := q.Order("audit_events.created_at DESC, audit_events.id").
+		Offset(offset).
+		Limit(limit).
+		Find(&es).Error
+	return es, total, err
+}
+
+func (ad *AuditEventDao) DeleteByOrganization(organizationId string) error {
+	return ad.db.Where("audit_events.organization_id = ?", organizationId).
+		Delete(&AuditEvent{}).Error
+}
+
+// ReassignUser replaces a user in the actor_id column
+func (ad *AuditEventDao) ReassignUser(fromUserId string, toUserId string) error {
+	return reassignUser(ad.db, "audit_events", fromUserId, toUserId, "actor_id")
+}
@@ -1,11 +1,22 @@
 package domain
 
-import "time"
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
 
 const (
 	AccountTypeGithub = "GITHUB"
+	AccountTypeGitlab = "GITLAB"
+	AccountTypeOidc   = "OIDC"
 )
 
+// GhostUserId is the user the rows and snippets of deleted accounts are given to,
+// it is created by migrations/8_ghost_user.sql
+const GhostUserId = "00000000-0000-0000-0000-000000000000"
+
 type User struct {
 	Id          string
 	Login       string
@@ -17,20 +28,97 @@ type User struct {
 	UpdatedAt   time.Time
 }
 
+// UserProfile is what other users can see of a user
+type UserProfile struct {
+	Id    string
+	Login string
+	// Provider is the one the account was created with, logins are unique per provider
+	Provider  string
+	Name      string
+	AvatarUrl string
+	// Email is only shown to the user and its organizations co-members
+	Email     string `json:",omitempty"`
+	CreatedAt time.Time
+	Stats     *UserStats `json:",omitempty"`
+}
+
+// UserStats counts the contributions of a user seen by the viewer of its profile
+type UserStats struct {
+	Snippets int64
+	// Edits only counts the snippets the user was the last to edit
+	Edits int64
+	// Worked is the number of queries the snippets of the user worked for
+	Worked int64
+}
+
 type GithubUser struct {
-	Id         string
-	GithubId   string
-	BorgUserId string
+	Id                    string
+	GithubId              string
+	BorgUserId            string
+	EncryptedToken        string     `json:"-"`
+	EncryptedRefreshToken string     `json:"-"`
+	TokenExpiresAt        *time.Time `json:"-"`
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+}
+
+type GitlabUser struct {
+	Id                    string
+	GitlabId              string
+	BorgUserId            string
+	EncryptedToken        string     `json:"-"`
+	EncryptedRefreshToken string     `json:"-"`
+	TokenExpiresAt        *time.Time `json:"-"`
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+}
+
+type OidcUser struct {
+	Id                    string
+	Issuer                string
+	Subject               string
+	BorgUserId            string
+	EncryptedToken        string     `json:"-"`
+	EncryptedRefreshToken string     `json:"-"`
+	TokenExpiresAt        *time.Time `json:"-"`
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+}
+
+// IdentityLink is the common view of the github_users, gitlab_users and oidc_users rows
+type IdentityLink struct {
+	Id       string
+	Provider string
+	// Issuer is only set for OpenID Connect links
+	Issuer         string `json:",omitempty"`
+	ProviderUserId string
+	BorgUserId     string
+	EncryptedToken string `json:"-"`
+	// what refreshes the token once it expired, github tokens never do
+	EncryptedRefreshToken string     `json:"-"`
+	TokenExpiresAt        *time.Time `json:"-"`
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+}
+
+type AccessToken struct {
+	Id string
+	// Token is only set when the token is issued, the database knows only its hash
+	Token     string `gorm:"-" json:",omitempty"`
+	TokenHash string `json:"-"`
+	// Name is set by the user for personal tokens
+	Name       string
+	Scopes     Scopes
+	IsPersonal int
+	UserId     string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
 }
 
-type AccessToken struct {
-	Id        string
-	Token     string
-	UserId    string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+func (a AccessToken) IsExpired() bool {
+	return a.ExpiresAt != nil && a.ExpiresAt.Before(time.Now())
 }
 
 type Organization struct {
@@ -40,30 +128,176 @@ type Organization struct {
 	UpdatedAt time.Time
 	CreatedBy string
 	UpdatedBy string
+	// DeletesAt is set while the organization waits to be deleted,
+	// its owner can restore it until then
+	DeletesAt *time.Time `json:",omitempty"`
+	// the members of the github organization, or of its team, are members of this one
+	GithubOrg  string `json:",omitempty"`
+	GithubTeam string `json:",omitempty"`
+}
+
+// IsDeleted tells if the organization is waiting to be deleted
+func (o Organization) IsDeleted() bool {
+	return o.DeletesAt != nil
+}
+
+// Index is the elasticsearch index holding the snippets of the organization,
+// keyed on the id so the name can change and never collides with another index
+func (o Organization) Index() string {
+	return organizationIndexPrefix + o.Id
+}
+
+const organizationIndexPrefix = "org-"
+
+// OrganizationIndexes matches the indexes of every organization
+const OrganizationIndexes = organizationIndexPrefix + "*"
+
+// OrganizationIdOfIndex returns the id of the organization owning an index,
+// false for the public and personal indexes
+func OrganizationIdOfIndex(index string) (string, bool) {
+	if !strings.HasPrefix(index, organizationIndexPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(index, organizationIndexPrefix), true
+}
+
+// OrganizationDetail is what the members of an organization see of it
+type OrganizationDetail struct {
+	Organization
+	Creator  *UserProfile `json:",omitempty"`
+	Members  int64
+	Admins   int64
+	Snippets int64
+}
+
+// OrganizationMember is a user as listed in an organization
+type OrganizationMember struct {
+	User     UserProfile
+	Role     string
+	JoinedAt time.Time
+	// JoinLinkId is the join link the member used, if any
+	JoinLinkId *string `json:",omitempty"`
 }
 
 type UserOrganization struct {
 	Id             string
 	UserId         string
 	OrganizationId string
-	IsAdmin        int
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	CreatedBy      string
-	UpdatedBy      string
+	Role           string
+	// JoinLinkId is the join link the user joined with, if any
+	JoinLinkId *string
+	// Source is the provider the membership is synced from, empty when managed in borg
+	Source    string `json:",omitempty"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	CreatedBy string
+	UpdatedBy string
+}
+
+// IsAdmin tells if the member manages the organization
+func (uo UserOrganization) IsAdmin() bool {
+	return RoleAtLeast(uo.Role, RoleAdmin)
+}
+
+// CanWrite tells if the member can write the snippets of the organization
+func (uo UserOrganization) CanWrite() bool {
+	return RoleAtLeast(uo.Role, RoleEditor)
 }
 
 type OrganizationJoinLink struct {
 	Id             string
 	OrganizationId string
-	Ttl            int64
-	CreatedAt      time.Time
-	CreatedBy      string
+	Name           string
+	// Ttl is the number of seconds the link is valid for, 0 never expires
+	Ttl int64
+	// MaxUses is the number of users who can join with the link, 0 is unlimited
+	MaxUses int64
+	Uses    int64
+	// Role is given to the users joining with the link
+	Role      string
+	RevokedAt *time.Time `json:",omitempty"`
+	CreatedAt time.Time
+	CreatedBy string
+}
+
+// ExpiresAt is nil for the links which never expire
+func (o OrganizationJoinLink) ExpiresAt() *time.Time {
+	if o.Ttl <= 0 {
+		return nil
+	}
+	t := o.CreatedAt.Add(time.Duration(o.Ttl) * time.Second)
+	return &t
 }
 
 func (o OrganizationJoinLink) IsExpired() bool {
-	if o.CreatedAt.Unix()+o.Ttl < time.Now().Unix() {
+	if t := o.ExpiresAt(); t != nil && t.Unix() < time.Now().Unix() {
 		return true
 	}
 	return false
 }
+
+// IsUsable tells if users can still join with the link
+func (o OrganizationJoinLink) IsUsable() bool {
+	return o.RevokedAt == nil && !o.IsExpired() && (o.MaxUses == 0 || o.Uses < o.MaxUses)
+}
+
+// JoinLinkPreview is what anyone holding a join link sees of it,
+// nothing in it can be used with other endpoints
+type JoinLinkPreview struct {
+	Organization string
+	Members      int64
+	// InvitedBy is the login of the user who created the link
+	InvitedBy string     `json:",omitempty"`
+	ExpiresAt *time.Time `json:",omitempty"`
+	Role      string
+	// Usable is false once the link expired or was used too many times
+	Usable bool
+}
+
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+)
+
+// OrganizationInvitation invites a github login or an email address to an organization,
+// only the user matching it can accept it
+type OrganizationInvitation struct {
+	Id             string
+	OrganizationId string
+	Login          string `json:",omitempty"`
+	Email          string `json:",omitempty"`
+	// Role is given to the user accepting the invitation
+	Role      string
+	Status    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	CreatedBy string
+	UpdatedBy string
+}
+
+// IsFor tells if the invitation targets the user, the email of a user
+// is always one its provider verified, see identity.Identity
+func (i OrganizationInvitation) IsFor(u User) bool {
+	if i.Email != "" {
+		return strings.EqualFold(i.Email, u.Email)
+	}
+	return i.Login != "" && u.AccountType == AccountTypeGithub && strings.EqualFold(i.Login, u.Login)
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// reassignUser replaces fromUserId by toUserId in the given columns of table
+func reassignUser(db *gorm.DB, table string, fromUserId string, toUserId string, columns ...string) error {
+	for _, column := range columns {
+		err := db.Table(table).Where(column+" = ?", fromUserId).
+			UpdateColumn(column, toUserId).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
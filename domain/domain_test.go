@@ -0,0 +1,26 @@
+package domain
+
+import "testing"
+
+func TestInvitationIsFor(t *testing.T) {
+	bob := User{Id: "1", Login: "bob", Email: "bob@example.com", AccountType: AccountTypeGithub}
+	tests := []struct {
+		name       string
+		invitation OrganizationInvitation
+		user       User
+		isFor      bool
+	}{
+		{"email", OrganizationInvitation{Email: "Bob@example.com"}, bob, true},
+		{"login", OrganizationInvitation{Login: "Bob"}, bob, true},
+		{"another email", OrganizationInvitation{Email: "carol@example.com"}, bob, false},
+		{"another login", OrganizationInvitation{Login: "carol"}, bob, false},
+		{"unverified email", OrganizationInvitation{Email: "bob@example.com"}, User{Id: "2", Login: "bob2"}, false},
+		{"login of another provider",
+			OrganizationInvitation{Login: "bob"}, User{Id: "3", Login: "bob", AccountType: AccountTypeGitlab}, false},
+	}
+	for _, test := range tests {
+		if test.invitation.IsFor(test.user) != test.isFor {
+			t.Errorf("%s: IsFor should be %v", test.name, test.isFor)
+		}
+	}
+}
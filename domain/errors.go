@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+)
+
+// mysql error number of a row breaking a unique index
+const errDuplicateEntry = 1062
+
+type ErrorKind int
+
+const (
+	ErrInternal ErrorKind = iota
+	ErrValidation
+	ErrUnauthorized
+	ErrForbidden
+	ErrNotFound
+	ErrConflict
+	ErrRateLimited
+)
+
+// Error is an error with a known cause,
+// the http layer maps its kind to a status code
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Details interface{}
+}
+
+func (e Error) Error() string {
+	return e.Message
+}
+
+func NewError(kind ErrorKind, format string, args ...interface{}) error {
+	return Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+func Invalidf(format string, args ...interface{}) error {
+	return NewError(ErrValidation, format, args...)
+}
+
+func Unauthorizedf(format string, args ...interface{}) error {
+	return NewError(ErrUnauthorized, format, args...)
+}
+
+func Forbiddenf(format string, args ...interface{}) error {
+	return NewError(ErrForbidden, format, args...)
+}
+
+func NotFoundf(format string, args ...interface{}) error {
+	return NewError(ErrNotFound, format, args...)
+}
+
+func Conflictf(format string, args ...interface{}) error {
+	return NewError(ErrConflict, format, args...)
+}
+
+// Kind returns the kind of err, a missing row is a not found error,
+// a duplicate one a conflict, anything unknown is internal
+func Kind(err error) ErrorKind {
+	if e, ok := err.(Error); ok {
+		return e.Kind
+	}
+	if err == gorm.ErrRecordNotFound {
+		return ErrNotFound
+	}
+	if IsDuplicate(err) {
+		return ErrConflict
+	}
+	return ErrInternal
+}
+
+// IsDuplicate tells if err comes from a row breaking a unique index
+func IsDuplicate(err error) bool {
+	e, ok := err.(*mysql.MySQLError)
+	return ok && e.Number == errDuplicateEntry
+}
@@ -20,3 +20,7 @@ func (gu *GithubUserDao) GetByGithubId(id string) (GithubUser, error) {
 		First(&model).Error
 	return model, err
 }
+
+func (gu *GithubUserDao) Update(model GithubUser) error {
+	return gu.db.Save(&model).Error
+}
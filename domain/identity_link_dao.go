@@ -0,0 +1,219 @@
+package domain
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// identity providers, each one has its own link table
+const (
+	ProviderGithub = "github"
+	ProviderGitlab = "gitlab"
+	ProviderOidc   = "oidc"
+)
+
+// IdentityLinkDao reads and writes the link tables of every provider
+type IdentityLinkDao struct {
+	db *gorm.DB
+}
+
+func NewIdentityLinkDao(db *gorm.DB) *IdentityLinkDao {
+	return &IdentityLinkDao{db: db}
+}
+
+// Get finds the link matching the provider, issuer and provider user id of key
+func (il *IdentityLinkDao) Get(key IdentityLink) (IdentityLink, error) {
+	switch key.Provider {
+	case ProviderGithub:
+		m := GithubUser{}
+		err := il.db.Where("github_users.github_id = ?", key.ProviderUserId).
+			First(&m).Error
+		return githubLink(m), err
+	case ProviderGitlab:
+		m := GitlabUser{}
+		err := il.db.Where("gitlab_users.gitlab_id = ?", key.ProviderUserId).
+			First(&m).Error
+		return gitlabLink(m), err
+	case ProviderOidc:
+		m := OidcUser{}
+		err := il.db.Where("oidc_users.issuer = ? AND oidc_users.subject = ?",
+			key.Issuer, key.ProviderUserId).
+			First(&m).Error
+		return oidcLink(m), err
+	}
+	return IdentityLink{}, unknownProvider(key.Provider)
+}
+
+func (il *IdentityLinkDao) Create(link IdentityLink) error {
+	switch link.Provider {
+	case ProviderGithub:
+		return il.db.Create(&GithubUser{
+			Id:                    link.Id,
+			GithubId:              link.ProviderUserId,
+			BorgUserId:            link.BorgUserId,
+			EncryptedToken:        link.EncryptedToken,
+			EncryptedRefreshToken: link.EncryptedRefreshToken,
+			TokenExpiresAt:        link.TokenExpiresAt,
+			CreatedAt:             link.CreatedAt,
+			UpdatedAt:             link.UpdatedAt,
+		}).Error
+	case ProviderGitlab:
+		return il.db.Create(&GitlabUser{
+			Id:                    link.Id,
+			GitlabId:              link.ProviderUserId,
+			BorgUserId:            link.BorgUserId,
+			EncryptedToken:        link.EncryptedToken,
+			EncryptedRefreshToken: link.EncryptedRefreshToken,
+			TokenExpiresAt:        link.TokenExpiresAt,
+			CreatedAt:             link.CreatedAt,
+			UpdatedAt:             link.UpdatedAt,
+		}).Error
+	case ProviderOidc:
+		return il.db.Create(&OidcUser{
+			Id:                    link.Id,
+			Issuer:                link.Issuer,
+			Subject:               link.ProviderUserId,
+			BorgUserId:            link.BorgUserId,
+			EncryptedToken:        link.EncryptedToken,
+			EncryptedRefreshToken: link.EncryptedRefreshToken,
+			TokenExpiresAt:        link.TokenExpiresAt,
+			CreatedAt:             link.CreatedAt,
+			UpdatedAt:             link.UpdatedAt,
+		}).Error
+	}
+	return unknownProvider(link.Provider)
+}
+
+// Update saves the borg user and the tokens of a link
+func (il *IdentityLinkDao) Update(link IdentityLink) error {
+	table, err := linkTable(link.Provider)
+	if err != nil {
+		return err
+	}
+	return il.db.Table(table).Where("id = ?", link.Id).
+		Updates(map[string]interface{}{
+			"borg_user_id":            link.BorgUserId,
+			"encrypted_token":         link.EncryptedToken,
+			"encrypted_refresh_token": link.EncryptedRefreshToken,
+			"token_expires_at":        link.TokenExpiresAt,
+			"updated_at":              link.UpdatedAt,
+		}).Error
+}
+
+// ListByBorgUser returns the links of a user across every provider
+func (il *IdentityLinkDao) ListByBorgUser(userId string) ([]IdentityLink, error) {
+	links := []IdentityLink{}
+	ghs := []GithubUser{}
+	if err := il.db.Where("github_users.borg_user_id = ?", userId).Find(&ghs).Error; err != nil {
+		return nil, err
+	}
+	for _, m := range ghs {
+		links = append(links, githubLink(m))
+	}
+	gls := []GitlabUser{}
+	if err := il.db.Where("gitlab_users.borg_user_id = ?", userId).Find(&gls).Error; err != nil {
+		return nil, err
+	}
+	for _, m := range gls {
+		links = append(links, gitlabLink(m))
+	}
+	oidcs := []OidcUser{}
+	if err := il.db.Where("oidc_users.borg_user_id = ?", userId).Find(&oidcs).Error; err != nil {
+		return nil, err
+	}
+	for _, m := range oidcs {
+		links = append(links, oidcLink(m))
+	}
+	return links, nil
+}
+
+// ReassignBorgUser moves every link of a user to another one
+func (il *IdentityLinkDao) ReassignBorgUser(fromUserId string, toUserId string) error {
+	for _, table := range []string{"github_users", "gitlab_users", "oidc_users"} {
+		err := il.db.Table(table).Where("borg_user_id = ?", fromUserId).
+			UpdateColumn("borg_user_id", toUserId).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteByBorgUser removes every link of a user
+func (il *IdentityLinkDao) DeleteByBorgUser(userId string) error {
+	for _, table := range []string{"github_users", "gitlab_users", "oidc_users"} {
+		err := il.db.Table(table).Where("borg_user_id = ?", userId).Delete(nil).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (il *IdentityLinkDao) Delete(provider string, id string) error {
+	table, err := linkTable(provider)
+	if err != nil {
+		return err
+	}
+	return il.db.Table(table).Where("id = ?", id).Delete(nil).Error
+}
+
+func linkTable(provider string) (string, error) {
+	switch provider {
+	case ProviderGithub:
+		return "github_users", nil
+	case ProviderGitlab:
+		return "gitlab_users", nil
+	case ProviderOidc:
+		return "oidc_users", nil
+	}
+	return "", unknownProvider(provider)
+}
+
+func unknownProvider(provider string) error {
+	return fmt.Errorf("unknown identity provider %s", provider)
+}
+
+func githubLink(m GithubUser) IdentityLink {
+	return IdentityLink{
+		Id:                    m.Id,
+		Provider:              ProviderGithub,
+		ProviderUserId:        m.GithubId,
+		BorgUserId:            m.BorgUserId,
+		EncryptedToken:        m.EncryptedToken,
+		EncryptedRefreshToken: m.EncryptedRefreshToken,
+		TokenExpiresAt:        m.TokenExpiresAt,
+		CreatedAt:             m.CreatedAt,
+		UpdatedAt:             m.UpdatedAt,
+	}
+}
+
+func gitlabLink(m GitlabUser) IdentityLink {
+	return IdentityLink{
+		Id:                    m.Id,
+		Provider:              ProviderGitlab,
+		ProviderUserId:        m.GitlabId,
+		BorgUserId:            m.BorgUserId,
+		EncryptedToken:        m.EncryptedToken,
+		EncryptedRefreshToken: m.EncryptedRefreshToken,
+		TokenExpiresAt:        m.TokenExpiresAt,
+		CreatedAt:             m.CreatedAt,
+		UpdatedAt:             m.UpdatedAt,
+	}
+}
+
+func oidcLink(m OidcUser) IdentityLink {
+	return IdentityLink{
+		Id:                    m.Id,
+		Provider:              ProviderOidc,
+		Issuer:                m.Issuer,
+		ProviderUserId:        m.Subject,
+		BorgUserId:            m.BorgUserId,
+		EncryptedToken:        m.EncryptedToken,
+		EncryptedRefreshToken: m.EncryptedRefreshToken,
+		TokenExpiresAt:        m.TokenExpiresAt,
+		CreatedAt:             m.CreatedAt,
+		UpdatedAt:             m.UpdatedAt,
+	}
+}
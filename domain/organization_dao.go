@@ -1,6 +1,10 @@
 package domain
 
-import "github.com/jinzhu/gorm"
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
 
 type OrganizationDao struct {
 	db *gorm.DB
@@ -16,6 +20,16 @@ func (od *OrganizationDao) GetById(id string) (Organization, error) {
 	return u, err
 }
 
+// GetForUpdate locks the organization until the end of the transaction,
+// the changes of its members are made one at a time under this lock
+func (od *OrganizationDao) GetForUpdate(id string) (Organization, error) {
+	u := Organization{}
+	err := od.db.Set("gorm:query_option", "FOR UPDATE").
+		Where("organizations.id = ?", id).
+		First(&u).Error
+	return u, err
+}
+
 func (od *OrganizationDao) GetByIds(ids []string) ([]Organization, error) {
 	u := []Organization{}
 	err := od.db.Where("organizations.id in (?)", ids).
@@ -35,7 +49,25 @@ func (od *OrganizationDao) MatchesInIds(
 ) ([]Organization, error) {
 	u := []Organization{}
 	err := od.db.Where("organizations.id in (?)", ids).
-		Where("organizations.name LIKE ?", pattern+"%").
+		Where("organizations.name LIKE ?", escapeLike(pattern)+"%").
+		Where("organizations.deletes_at IS NULL").
+		Find(&u).Error
+	return u, err
+}
+
+// ListDeletable returns the organizations whose grace period ended before t
+func (od *OrganizationDao) ListDeletable(t time.Time) ([]Organization, error) {
+	u := []Organization{}
+	err := od.db.Where("organizations.deletes_at <= ?", t).
+		Find(&u).Error
+	return u, err
+}
+
+// ListLinkedToGithub returns the organizations linked to a github organization
+func (od *OrganizationDao) ListLinkedToGithub() ([]Organization, error) {
+	u := []Organization{}
+	err := od.db.Where("organizations.github_org != ''").
+		Where("organizations.deletes_at IS NULL").
 		Find(&u).Error
 	return u, err
 }
@@ -47,3 +79,12 @@ func (od *OrganizationDao) Create(u Organization) error {
 func (od *OrganizationDao) Update(u Organization) error {
 	return od.db.Save(&u).Error
 }
+
+func (od *OrganizationDao) Delete(id string) error {
+	return od.db.Delete(&Organization{Id: id}).Error
+}
+
+// ReassignUser replaces a user in the created_by and updated_by columns
+func (od *OrganizationDao) ReassignUser(fromUserId string, toUserId string) error {
+	return reassignUser(od.db, "organizations", fromUserId, toUserId, "created_by", "updated_by")
+}
@@ -0,0 +1,66 @@
+package domain
+
+import "github.com/jinzhu/gorm"
+
+type OrganizationInvitationDao struct {
+	db *gorm.DB
+}
+
+func NewOrganizationInvitationDao(db *gorm.DB) *OrganizationInvitationDao {
+	return &OrganizationInvitationDao{db: db}
+}
+
+func (od *OrganizationInvitationDao) GetById(id string) (OrganizationInvitation, error) {
+	i := OrganizationInvitation{Id: id}
+	err := od.db.First(&i).Error
+	return i, err
+}
+
+// GetForUpdate locks the invitation until the end of the transaction
+func (od *OrganizationInvitationDao) GetForUpdate(id string) (OrganizationInvitation, error) {
+	i := OrganizationInvitation{}
+	err := od.db.Set("gorm:query_option", "FOR UPDATE").
+		Where("organization_invitations.id = ?", id).
+		First(&i).Error
+	return i, err
+}
+
+// ListPendingFor returns the pending invitations for the login or the email, latest first.
+// An empty login or email matches nothing.
+func (od *OrganizationInvitationDao) ListPendingFor(login string, email string) ([]OrganizationInvitation, error) {
+	is := []OrganizationInvitation{}
+	err := od.db.Where("organization_invitations.status = ?", InvitationPending).
+		Where("(organization_invitations.login = ? AND ? != '') OR (organization_invitations.email = ? AND ? != '')",
+			login, login, email, email).
+		Order("organization_invitations.created_at DESC").
+		Find(&is).Error
+	return is, err
+}
+
+// GetPending returns the pending invitation of an organization for the login or the email
+func (od *OrganizationInvitationDao) GetPending(organizationId string, login string, email string) (OrganizationInvitation, error) {
+	i := OrganizationInvitation{}
+	err := od.db.Where("organization_invitations.organization_id = ?", organizationId).
+		Where("organization_invitations.status = ?", InvitationPending).
+		Where("organization_invitations.login = ? AND organization_invitations.email = ?", login, email).
+		First(&i).Error
+	return i, err
+}
+
+func (od *OrganizationInvitationDao) Create(i OrganizationInvitation) error {
+	return od.db.Create(&i).Error
+}
+
+func (od *OrganizationInvitationDao) Update(i OrganizationInvitation) error {
+	return od.db.Save(&i).Error
+}
+
+func (od *OrganizationInvitationDao) DeleteByOrganization(organizationId string) error {
+	return od.db.Where("organization_invitations.organization_id = ?", organizationId).
+		Delete(&OrganizationInvitation{}).Error
+}
+
+// ReassignUser replaces a user in the created_by and updated_by columns
+func (od *OrganizationInvitationDao) ReassignUser(fromUserId string, toUserId string) error {
+	return reassignUser(od.db, "organization_invitations", fromUserId, toUserId, "created_by", "updated_by")
+}
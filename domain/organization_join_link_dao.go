@@ -16,13 +16,34 @@ func (od *OrganizationJoinLinkDao) GetById(id string) (OrganizationJoinLink, err
 	return u, err
 }
 
+// GetForUpdate locks the link until the end of the transaction
+func (od *OrganizationJoinLinkDao) GetForUpdate(id string) (OrganizationJoinLink, error) {
+	u := OrganizationJoinLink{}
+	err := od.db.Set("gorm:query_option", "FOR UPDATE").
+		Where("organization_join_links.id = ?", id).
+		First(&u).Error
+	return u, err
+}
+
+// GetByOrganizationId returns the latest link of an organization not revoked
 func (od *OrganizationJoinLinkDao) GetByOrganizationId(id string) (OrganizationJoinLink, error) {
 	o := OrganizationJoinLink{}
 	err := od.db.Where("organization_id = ?", id).
+		Where("organization_join_links.revoked_at IS NULL").
+		Order("organization_join_links.created_at DESC").
 		First(&o).Error
 	return o, err
 }
 
+// ListByOrganization returns all the links of an organization, latest first
+func (od *OrganizationJoinLinkDao) ListByOrganization(organizationId string) ([]OrganizationJoinLink, error) {
+	links := []OrganizationJoinLink{}
+	err := od.db.Where("organization_join_links.organization_id = ?", organizationId).
+		Order("organization_join_links.created_at DESC").
+		Find(&links).Error
+	return links, err
+}
+
 func (od *OrganizationJoinLinkDao) Create(u OrganizationJoinLink) error {
 	return od.db.Create(&u).Error
 }
@@ -31,6 +52,16 @@ func (od *OrganizationJoinLinkDao) Update(u OrganizationJoinLink) error {
 	return od.db.Save(&u).Error
 }
 
+// ReassignUser replaces a user in the created_by column
+func (od *OrganizationJoinLinkDao) ReassignUser(fromUserId string, toUserId string) error {
+	return reassignUser(od.db, "organization_join_links", fromUserId, toUserId, "created_by")
+}
+
+func (od *OrganizationJoinLinkDao) DeleteByOrganization(organizationId string) error {
+	return od.db.Where("organization_join_links.organization_id = ?", organizationId).
+		Delete(&OrganizationJoinLink{}).Error
+}
+
 func (od *OrganizationJoinLinkDao) Delete(id string) error {
 	return od.db.Delete(&OrganizationJoinLink{Id: id}).Error
 }
@@ -0,0 +1,33 @@
+package domain
+
+// roles of the members of an organization, each one can do what the ones below can.
+// viewers read the snippets, editors write them, admins manage the members
+// and the owner, alone in its organization, can give it away or delete it.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+	RoleOwner:  4,
+}
+
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// RoleAtLeast tells if role has at least the rights of min
+func RoleAtLeast(role string, min string) bool {
+	return roleRanks[role] >= roleRanks[min]
+}
+
+// RoleAbove tells if role has more rights than other
+func RoleAbove(role string, other string) bool {
+	return roleRanks[role] > roleRanks[other]
+}
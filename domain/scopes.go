@@ -0,0 +1,64 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// access token scopes
+const (
+	ScopeRead     = "read"
+	ScopeWrite    = "write"
+	ScopeOrgAdmin = "org-admin"
+)
+
+// AllScopes are given to the tokens issued at login
+var AllScopes = Scopes{ScopeRead, ScopeWrite, ScopeOrgAdmin}
+
+// Scopes is stored as a comma separated list
+type Scopes []string
+
+func (s Scopes) Has(scope string) bool {
+	for _, v := range s {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks every scope is known
+func (s Scopes) Validate() error {
+	if len(s) == 0 {
+		return Invalidf("at least one scope is required")
+	}
+	for _, v := range s {
+		if !AllScopes.Has(v) {
+			return Invalidf("unknown scope %s", v)
+		}
+	}
+	return nil
+}
+
+func (s Scopes) Value() (driver.Value, error) {
+	return strings.Join(s, ","), nil
+}
+
+func (s *Scopes) Scan(src interface{}) error {
+	var raw string
+	switch v := src.(type) {
+	case []byte:
+		raw = string(v)
+	case string:
+		raw = v
+	case nil:
+	default:
+		return fmt.Errorf("cannot scan %T into Scopes", src)
+	}
+	*s = Scopes{}
+	if raw != "" {
+		*s = strings.Split(raw, ",")
+	}
+	return nil
+}
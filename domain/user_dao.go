@@ -23,13 +23,31 @@ func (ud *UserDao) GetByIds(ids []string) ([]User, error) {
 	return us, err
 }
 
-func (ud *UserDao) GetByEmailOrUsername(str string) (User, error) {
+func (ud *UserDao) GetByEmailOrLogin(str string) (User, error) {
 	u := User{}
-	err := ud.db.Where("users.email = ? OR users.username = ?", str, str).
+	err := ud.db.Where("users.email = ? OR users.login = ?", str, str).
 		First(&u).Error
 	return u, err
 }
 
+// Search returns the users whose login or name starts with q,
+// or whose email does when viewerId may see it: its own and the ones of its co-members
+func (ud *UserDao) Search(q string, viewerId string, limit int) ([]User, error) {
+	us := []User{}
+	like := escapeLike(q) + "%"
+	err := ud.db.Where("users.login LIKE ? OR users.name LIKE ? OR "+
+		"(users.email LIKE ? AND (users.id = ? OR users.id IN ("+
+		"SELECT theirs.user_id FROM user_organizations mine "+
+		"JOIN user_organizations theirs ON theirs.organization_id = mine.organization_id "+
+		"WHERE mine.user_id = ?)))",
+		like, like, like, viewerId, viewerId).
+		Where("users.login <> ''").
+		Order("users.login").
+		Limit(limit).
+		Find(&us).Error
+	return us, err
+}
+
 func (ud *UserDao) GetByEmail(email string) (User, error) {
 	u := User{}
 	err := ud.db.Where("users.email = ?", email).
@@ -37,9 +55,14 @@ func (ud *UserDao) GetByEmail(email string) (User, error) {
 	return u, err
 }
 
-func (ud *UserDao) GetByLogin(login string) (User, error) {
+// GetByLoginAndAccountType finds a user by login, logins are only unique per account type
+func (ud *UserDao) GetByLoginAndAccountType(login string, accountType string) (User, error) {
 	u := User{}
-	err := ud.db.Where("users.login = ?", login).
+	// released logins are empty
+	if login == "" {
+		return u, gorm.ErrRecordNotFound
+	}
+	err := ud.db.Where("users.login = ? AND users.account_type = ?", login, accountType).
 		First(&u).Error
 	return u, err
 }
@@ -51,3 +74,16 @@ func (ud *UserDao) Create(u User) error {
 func (ud *UserDao) Update(u User) error {
 	return ud.db.Save(&u).Error
 }
+
+// ReleaseLogin empties the login of the other accounts of the same type holding it,
+// they renamed themselves at the provider and their next sync gives them their new login
+func (ud *UserDao) ReleaseLogin(login string, accountType string, exceptUserId string) error {
+	return ud.db.Model(&User{}).
+		Where("users.login = ? AND users.account_type = ? AND users.id <> ?",
+			login, accountType, exceptUserId).
+		UpdateColumn("login", "").Error
+}
+
+func (ud *UserDao) Delete(id string) error {
+	return ud.db.Delete(&User{Id: id}).Error
+}
@@ -70,6 +70,88 @@ func (ud *UserOrganizationDao) ListOrganizationsForUser(user_id string) ([]strin
 	return uids, nil
 }
 
+// FilterCoMembers returns the users of userIds sharing an organization with userId
+func (ud *UserOrganizationDao) FilterCoMembers(userId string, userIds []string) ([]string, error) {
+	ids := []string{}
+	if len(userIds) == 0 {
+		return ids, nil
+	}
+	err := ud.db.Table("user_organizations mine").
+		Joins("JOIN user_organizations theirs ON theirs.organization_id = mine.organization_id").
+		Where("mine.user_id = ? AND theirs.user_id IN (?)", userId, userIds).
+		Pluck("DISTINCT theirs.user_id", &ids).Error
+	return ids, err
+}
+
+// ListByOrganization returns the memberships of an organization, oldest first
+func (ud *UserOrganizationDao) ListByOrganization(organization_id string) ([]UserOrganization, error) {
+	uos := []UserOrganization{}
+	err := ud.db.Where("user_organizations.organization_id = ?", organization_id).
+		Order("user_organizations.created_at").
+		Find(&uos).Error
+	return uos, err
+}
+
+// ListPageByOrganization returns a page of the memberships of an organization, oldest first
+func (ud *UserOrganizationDao) ListPageByOrganization(
+	organization_id string,
+	offset int,
+	limit int,
+) ([]UserOrganization, error) {
+	uos := []UserOrganization{}
+	err := ud.db.Where("user_organizations.organization_id = ?", organization_id).
+		Order("user_organizations.created_at, user_organizations.id").
+		Offset(offset).
+		Limit(limit).
+		Find(&uos).Error
+	return uos, err
+}
+
+// CountByOrganization returns the number of members and admins of an organization
+func (ud *UserOrganizationDao) CountByOrganization(organization_id string) (int64, int64, error) {
+	var members, admins int64
+	err := ud.db.Model(&UserOrganization{}).
+		Where("user_organizations.organization_id = ?", organization_id).
+		Count(&members).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	err = ud.db.Model(&UserOrganization{}).
+		Where("user_organizations.organization_id = ? AND user_organizations.role IN (?)",
+			organization_id, []string{RoleAdmin, RoleOwner}).
+		Count(&admins).Error
+	return members, admins, err
+}
+
+func (ud *UserOrganizationDao) ListByUser(user_id string) ([]UserOrganization, error) {
+	uos := []UserOrganization{}
+	err := ud.db.Where("user_organizations.user_id = ?", user_id).
+		Find(&uos).Error
+	return uos, err
+}
+
+// ReassignUser replaces a user in the created_by and updated_by columns,
+// memberships themselves are moved one by one
+func (ud *UserOrganizationDao) ReassignUser(fromUserId string, toUserId string) error {
+	return reassignUser(ud.db, "user_organizations", fromUserId, toUserId, "created_by", "updated_by")
+}
+
+// ListUserIdsBySource returns the users having a membership synced from the source
+func (ud *UserOrganizationDao) ListUserIdsBySource(source string) ([]string, error) {
+	ids := []string{}
+	err := ud.db.Model(&UserOrganization{}).
+		Where("user_organizations.source = ?", source).
+		Pluck("DISTINCT user_organizations.user_id", &ids).Error
+	return ids, err
+}
+
+// DetachSource turns the synced memberships of an organization into regular ones
+func (ud *UserOrganizationDao) DetachSource(organizationId string) error {
+	return ud.db.Model(&UserOrganization{}).
+		Where("user_organizations.organization_id = ?", organizationId).
+		UpdateColumn("source", "").Error
+}
+
 func (ud *UserOrganizationDao) Create(u UserOrganization) error {
 	return ud.db.Create(&u).Error
 }
@@ -82,9 +164,14 @@ func (ud *UserOrganizationDao) Delete(id string) error {
 	return ud.db.Delete(&UserOrganization{Id: id}).Error
 }
 
+func (ud *UserOrganizationDao) DeleteByOrganization(organizationId string) error {
+	return ud.db.Where("user_organizations.organization_id = ?", organizationId).
+		Delete(&UserOrganization{}).Error
+}
+
 func (ud *UserOrganizationDao) GetAdmins(organizastionId string) ([]UserOrganization, error) {
 	models := []UserOrganization{}
 	return models, ud.db.Where("user_organizations.organization_id = ?", organizastionId).
-		Where("user_organizations.is_admin = 1").
+		Where("user_organizations.role IN (?)", []string{RoleAdmin, RoleOwner}).
 		Find(&models).Error
 }
@@ -0,0 +1,213 @@
+package endpoints
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/reqlog"
+	"github.com/ok-borg/api/types"
+)
+
+// UserExport is everything borg knows about a user
+type UserExport struct {
+	Profile       domain.User
+	Identities    []domain.IdentityLink
+	AccessTokens  []domain.AccessToken
+	Organizations []ExportedMembership
+	// Snippets are the personal ones, the others belong to their index
+	Snippets []types.Problem
+}
+
+type ExportedMembership struct {
+	Organization domain.Organization
+	Role         string
+	JoinedAt     time.Time
+}
+
+// ExportUser gathers the data of a user
+func (e Endpoints) ExportUser(db *gorm.DB, userId string) (*UserExport, error) {
+	x := UserExport{}
+	var err error
+	if x.Profile, err = domain.NewUserDao(db).GetById(userId); err != nil {
+		return nil, err
+	}
+	if x.Identities, err = domain.NewIdentityLinkDao(db).ListByBorgUser(userId); err != nil {
+		return nil, err
+	}
+	// only the metadata, tokens are stored hashed
+	if x.AccessTokens, err = domain.NewAccessTokenDao(db).ListByUser(userId); err != nil {
+		return nil, err
+	}
+	uos, err := domain.NewUserOrganizationDao(db).ListByUser(userId)
+	if err != nil {
+		return nil, err
+	}
+	organizationDao := domain.NewOrganizationDao(db)
+	for _, uo := range uos {
+		o, err := organizationDao.GetById(uo.OrganizationId)
+		if err != nil {
+			return nil, err
+		}
+		x.Organizations = append(x.Organizations, ExportedMembership{
+			Organization: o,
+			Role:         uo.Role,
+			JoinedAt:     uo.CreatedAt,
+		})
+	}
+	if x.Snippets, err = e.allSnippets(userId); err != nil {
+		return nil, err
+	}
+	return &x, nil
+}
+
+// WriteArchive writes the export as a zip holding one json file per kind of data
+func (x UserExport) WriteArchive(w io.Writer) error {
+	z := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", x.Profile},
+		{"identities.json", x.Identities},
+		{"access_tokens.json", x.AccessTokens},
+		{"organizations.json", x.Organizations},
+		{"snippets.json", x.Snippets},
+	}
+	for _, f := range files {
+		fw, err := z.Create(f.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// DeleteUser deletes an account, confirm must be its login or id.
+// The organizations the user owns are given to their oldest admin, or oldest member,
+// or are deleted with their snippets when nobody else is left.
+// Public and organization snippets are kept, anonymised, the personal ones are deleted.
+func (e Endpoints) DeleteUser(ctx context.Context, db *gorm.DB, userId string, confirm string) error {
+	if userId == domain.GhostUserId {
+		return domain.Forbiddenf("the ghost user cannot be deleted")
+	}
+	u, err := domain.NewUserDao(db).GetById(userId)
+	if err != nil {
+		return err
+	}
+	if confirm == "" || (confirm != u.Login && confirm != u.Id) {
+		return domain.Invalidf("confirm with the login of the account to delete it")
+	}
+
+	tx := db.Begin()
+	deletedOrgs, err := deleteUserRows(tx, userId)
+	if err != nil {
+		tx.Rollback()
+		reqlog.Errorf(ctx, "[Endpoints.DeleteUser] unable to delete user %s: %s", userId, err.Error())
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	reqlog.Infof(ctx, "user %s deleted", userId)
+
+	// the account is gone and cannot be deleted again,
+	// whatever fails from here is logged to be finished by hand
+	indexes := append([]string{userId}, deletedOrgs...)
+	for _, index := range indexes {
+		if err := e.dropIndex(index); err != nil {
+			reqlog.Errorf(ctx, "[Endpoints.DeleteUser] unable to drop index %s of deleted user %s: %s",
+				index, userId, err.Error())
+		}
+	}
+	if err := e.reassignSnippets(ctx, userId, domain.GhostUserId); err != nil {
+		reqlog.Errorf(ctx, "[Endpoints.DeleteUser] unable to anonymise snippets of deleted user %s: %s",
+			userId, err.Error())
+	}
+	return nil
+}
+
+// deleteUserRows deletes a user and returns the indexes of the organizations deleted with it
+func deleteUserRows(tx *gorm.DB, userId string) ([]string, error) {
+	userOrganizationDao := domain.NewUserOrganizationDao(tx)
+	organizationDao := domain.NewOrganizationDao(tx)
+	deletedOrgs := []string{}
+	uos, err := userOrganizationDao.ListByUser(userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, uo := range uos {
+		if err := userOrganizationDao.Delete(uo.Id); err != nil {
+			return nil, err
+		}
+		if uo.Role != domain.RoleOwner {
+			continue
+		}
+		// nobody joins or leaves while the heir is chosen
+		if _, err := lockOrganization(tx, uo.OrganizationId); err != nil {
+			return nil, err
+		}
+		members, err := userOrganizationDao.ListByOrganization(uo.OrganizationId)
+		if err != nil {
+			return nil, err
+		}
+		if len(members) > 0 {
+			// the oldest admin takes over, or the oldest member without admins
+			heir := members[0]
+			for _, m := range members {
+				if m.IsAdmin() {
+					heir = m
+					break
+				}
+			}
+			heir.Role = domain.RoleOwner
+			heir.UpdatedAt = time.Now()
+			heir.UpdatedBy = domain.GhostUserId
+			if err := userOrganizationDao.Update(heir); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		o, err := organizationDao.GetById(uo.OrganizationId)
+		if err != nil {
+			return nil, err
+		}
+		if err := deleteOrganizationRows(tx, o.Id); err != nil {
+			return nil, err
+		}
+		deletedOrgs = append(deletedOrgs, o.Index())
+	}
+
+	// what the user did in the organizations stays, done by nobody
+	if err := userOrganizationDao.ReassignUser(userId, domain.GhostUserId); err != nil {
+		return nil, err
+	}
+	if err := organizationDao.ReassignUser(userId, domain.GhostUserId); err != nil {
+		return nil, err
+	}
+	if err := domain.NewOrganizationJoinLinkDao(tx).ReassignUser(userId, domain.GhostUserId); err != nil {
+		return nil, err
+	}
+	if err := domain.NewOrganizationInvitationDao(tx).ReassignUser(userId, domain.GhostUserId); err != nil {
+		return nil, err
+	}
+	if err := domain.NewAuditEventDao(tx).ReassignUser(userId, domain.GhostUserId); err != nil {
+		return nil, err
+	}
+	if err := domain.NewIdentityLinkDao(tx).DeleteByBorgUser(userId); err != nil {
+		return nil, err
+	}
+	if err := domain.NewAccessTokenDao(tx).DeleteByUser(userId); err != nil {
+		return nil, err
+	}
+	return deletedOrgs, domain.NewUserDao(tx).Delete(userId)
+}
@@ -0,0 +1,78 @@
+package endpoints
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/reqlog"
+	"github.com/satori/go.uuid"
+)
+
+const maxAuditEvents = 100
+
+// ListAuditEvents returns a page of the audit log of an organization and the number
+// of events matching the filter, to its admins only
+func (e Endpoints) ListAuditEvents(
+	db *gorm.DB,
+	userId string,
+	filter domain.AuditFilter,
+	from int,
+	size int,
+) ([]domain.AuditEvent, int64, error) {
+	if from < 0 || size <= 0 || size > maxAuditEvents {
+		return nil, 0, domain.Invalidf("size must be between 1 and %d, from cannot be negative", maxAuditEvents)
+	}
+	uo, err := requireMember(db, userId, filter.OrganizationId)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !uo.IsAdmin() {
+		return nil, 0, domain.Forbiddenf(
+			"user (id=%s) is not administrator of organization (id=%s)",
+			userId, filter.OrganizationId)
+	}
+	return domain.NewAuditEventDao(db).List(filter, from, size)
+}
+
+// recordAudit adds an event to the audit log of its organization
+func recordAudit(db *gorm.DB, event domain.AuditEvent) error {
+	event.Id = uuid.NewV4().String()
+	event.CreatedAt = time.Now()
+	return domain.NewAuditEventDao(db).Create(event)
+}
+
+// withAudit runs write and records the event in the same transaction
+func withAudit(db *gorm.DB, event domain.AuditEvent, write func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+	if err := write(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := recordAudit(tx, event); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// auditSnippet records a change of a snippet of an organization index,
+// the snippet is already saved so a failure is only logged
+func (e Endpoints) auditSnippet(ctx context.Context, index string, userId string, action string, snippetId string, title string) {
+	organizationId, ok := domain.OrganizationIdOfIndex(index)
+	if !ok {
+		return
+	}
+	err := recordAudit(e.db, domain.AuditEvent{
+		OrganizationId: organizationId,
+		ActorId:        userId,
+		Action:         action,
+		TargetId:       snippetId,
+		Details:        title,
+	})
+	if err != nil {
+		reqlog.Errorf(ctx, "[Endpoints.auditSnippet] unable to record %s of snippet %s in organization %s: %s",
+			action, snippetId, organizationId, err.Error())
+	}
+}
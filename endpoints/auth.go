@@ -1,108 +1,207 @@
 package endpoints
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"strconv"
 	"time"
 
-	"github.com/google/go-github/github"
 	"github.com/jinzhu/gorm"
 	"github.com/jpillora/go-ogle-analytics"
 	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/identity"
+	"github.com/ok-borg/api/notify"
+	"github.com/ok-borg/api/reqlog"
+	"github.com/ok-borg/api/secrets"
 	"github.com/satori/go.uuid"
-	"golang.org/x/oauth2"
 	"gopkg.in/olivere/elastic.v3"
 )
 
 // NewEndpoints is just below the http handlers
 func NewEndpoints(
-	oauthCfg *oauth2.Config,
+	providers identity.Providers,
 	client *elastic.Client,
 	a *ga.Client,
 	db *gorm.DB,
+	tokenTtl time.Duration,
+	redirectUrls []string,
+	organizationGracePeriod time.Duration,
+	notifier notify.Notifier,
 ) *Endpoints {
 	return &Endpoints{
-		oauthCfg:  oauthCfg,
-		client:    client,
-		analytics: a,
-		db:        db,
+		providers:               providers,
+		client:                  client,
+		analytics:               a,
+		db:                      db,
+		tokenTtl:                tokenTtl,
+		redirectUrls:            redirectUrls,
+		organizationGracePeriod: organizationGracePeriod,
+		notifier:                notifier,
 	}
 }
 
 // Endpoints represents all endpoints of the http server
 type Endpoints struct {
-	oauthCfg  *oauth2.Config
+	providers identity.Providers
 	client    *elastic.Client
 	analytics *ga.Client
 	db        *gorm.DB
+	tokenTtl  time.Duration
+	// redirect urls a client may ask for besides the ones of the providers
+	redirectUrls []string
+	// how long a deleted organization can be restored
+	organizationGracePeriod time.Duration
+	// mails users, about their invitations for example
+	notifier notify.Notifier
 }
 
-func githubUserToBorgUser(user *github.User) domain.User {
-	ret := domain.User{
+var accountTypes = map[string]string{
+	identity.Github: domain.AccountTypeGithub,
+	identity.Gitlab: domain.AccountTypeGitlab,
+	identity.Oidc:   domain.AccountTypeOidc,
+}
+
+func identityToBorgUser(id identity.Identity) domain.User {
+	return domain.User{
 		Id:          uuid.NewV4().String(),
-		Login:       *user.Login,
-		AvatarUrl:   *user.AvatarURL,
-		AccountType: domain.AccountTypeGithub,
+		Login:       id.Login,
+		Name:        id.Name,
+		Email:       id.Email,
+		AvatarUrl:   id.AvatarUrl,
+		AccountType: accountTypes[id.Provider],
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
-	if user.Email != nil {
-		ret.Email = *user.Email
+}
+
+func (e *Endpoints) provider(name string) (identity.Provider, error) {
+	p, ok := e.providers[name]
+	if !ok {
+		return nil, domain.NotFoundf("unknown identity provider %s", name)
+	}
+	return p, nil
+}
+
+// AuthorizeURL returns where to redirect the user to log in with the provider,
+// an empty redirectUrl means the one configured for the provider
+func (e *Endpoints) AuthorizeURL(
+	providerName string,
+	redirectUrl string,
+	codeChallenge string,
+) (string, error) {
+	p, err := e.provider(providerName)
+	if err != nil {
+		return "", err
+	}
+	if codeChallenge == "" {
+		return "", domain.Invalidf("code_challenge is required")
 	}
-	if user.Name != nil {
-		ret.Name = *user.Name
+	if redirectUrl != "" && !e.allowedRedirectUrl(redirectUrl) {
+		return "", domain.Invalidf("redirect_uri %s is not allowed", redirectUrl)
 	}
-	return ret
+	state, err := identity.NewState(providerName, redirectUrl, codeChallenge)
+	if err != nil {
+		return "", fmt.Errorf("error creating state: %s", err.Error())
+	}
+	return p.AuthorizeURL(state, identity.AuthRequest{
+		RedirectUrl:   redirectUrl,
+		CodeChallenge: codeChallenge,
+	})
 }
 
-// GithubAuth exchanges a github code for a token, registers and returns a User
-func (e *Endpoints) GithubAuth(code string) (*domain.User, *domain.AccessToken, error) {
+func (e *Endpoints) allowedRedirectUrl(redirectUrl string) bool {
+	for _, u := range e.redirectUrls {
+		if u == redirectUrl {
+			return true
+		}
+	}
+	return false
+}
+
+// exchange checks the state of the login then trades the code for the identity
+func (e *Endpoints) exchange(
+	ctx context.Context,
+	providerName string,
+	code string,
+	state string,
+	codeVerifier string,
+) (identity.Identity, error) {
 	if len(code) == 0 {
-		return nil, nil, errors.New("Code received is empty")
+		return identity.Identity{}, domain.Invalidf("Code received is empty")
 	}
-	tkn, err := e.oauthCfg.Exchange(oauth2.NoContext, code)
+	p, err := e.provider(providerName)
 	if err != nil {
-		return nil, nil, fmt.Errorf("there was an issue getting your token: %v", err)
+		return identity.Identity{}, err
 	}
-	if !tkn.Valid() {
-		return nil, nil, errors.New("Reretreived invalid token")
+	st, err := identity.VerifyState(state, providerName, codeVerifier)
+	if err != nil {
+		return identity.Identity{}, domain.Unauthorizedf("%s", err.Error())
 	}
-	client := github.NewClient(e.oauthCfg.Client(oauth2.NoContext, tkn))
-	user, _, err := client.Users.Get("")
+	id, err := p.Exchange(ctx, code, identity.AuthRequest{
+		RedirectUrl:  st.RedirectUrl,
+		CodeVerifier: codeVerifier,
+	})
 	if err != nil {
-		return nil, nil, fmt.Errorf("error getting name: %v", err)
+		return identity.Identity{}, domain.Unauthorizedf("%s", err.Error())
 	}
-	// here we got a github user
-	// first check if a github_users row exists with this github_id.
-	// if yes just save the token and associated it to the borg user linked to the github user
-	// if no, create a borg_users from the github users, associated both in a github_users row
+	return id, nil
+}
+
+// Login exchanges a provider code for an identity, registers and returns a User
+func (e *Endpoints) Login(
+	ctx context.Context,
+	providerName string,
+	code string,
+	state string,
+	codeVerifier string,
+) (*domain.User, *domain.AccessToken, error) {
+	id, err := e.exchange(ctx, providerName, code, state, codeVerifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	// here we got a provider identity
+	// first check if a link row exists with this provider user id.
+	// if yes just save the token and associated it to the borg user linked to the identity
+	// if no, create a borg_users from the identity, associated both in a link row
 	// and finally create the access_token in db.
-	ghUserDao := domain.NewGithubUserDao(e.db)
+	linkDao := domain.NewIdentityLinkDao(e.db)
 
 	var borgUser domain.User
 
-	ghUser, err := ghUserDao.GetByGithubId(fmt.Sprintf("%v", *user.ID))
-	if err != nil {
-		// github user do not exist
+	link, err := linkDao.Get(domain.IdentityLink{
+		Provider:       id.Provider,
+		Issuer:         id.Issuer,
+		ProviderUserId: id.Id,
+	})
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, nil, fmt.Errorf("error getting %s user %s", id.Provider, err.Error())
+	}
+	if err == gorm.ErrRecordNotFound {
+		// identity do not exist
 		// so the borg user cannot exists too
 		// first create it
-		newUser := githubUserToBorgUser(user)
-		userDao := domain.NewUserDao(e.db)
-		if err := userDao.Create(newUser); err != nil {
-			return nil, nil, fmt.Errorf("error creating new user %s", err.Error())
+		newUser := identityToBorgUser(id)
+		// the link to our new borg user, the provider token
+		// is only kept to sync profiles and organizations
+		newLink := domain.IdentityLink{
+			Id:             uuid.NewV4().String(),
+			Provider:       id.Provider,
+			Issuer:         id.Issuer,
+			ProviderUserId: id.Id,
+			BorgUserId:     newUser.Id,
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
 		}
-
-		// create the github user to link to our new borg user
-		newGithubUser := domain.GithubUser{
-			Id:         uuid.NewV4().String(),
-			GithubId:   strconv.Itoa(*user.ID),
-			BorgUserId: newUser.Id,
-			CreatedAt:  time.Now(),
-			UpdatedAt:  time.Now(),
+		if err := setLinkToken(&newLink, id.Token); err != nil {
+			return nil, nil, err
+		}
+		// a user never exists without the link it logs in with
+		tx := e.db.Begin()
+		if err := registerUser(tx, newUser, newLink); err != nil {
+			tx.Rollback()
+			return nil, nil, err
 		}
-		if err := ghUserDao.Create(newGithubUser); err != nil {
-			return nil, nil, fmt.Errorf("error creating new github user %s", err.Error())
+		if err := tx.Commit().Error; err != nil {
+			return nil, nil, fmt.Errorf("error creating new user %s", err.Error())
 		}
 
 		borgUser = newUser
@@ -110,30 +209,73 @@ func (e *Endpoints) GithubAuth(code string) (*domain.User, *domain.AccessToken,
 	} else {
 		var err error
 		userDao := domain.NewUserDao(e.db)
-		borgUser, err = userDao.GetById(ghUser.BorgUserId)
+		borgUser, err = userDao.GetById(link.BorgUserId)
 		if err != nil {
 			return nil, nil, fmt.Errorf("error getting user %s", err.Error())
 		}
+		if err := setLinkToken(&link, id.Token); err != nil {
+			return nil, nil, err
+		}
+		link.UpdatedAt = time.Now()
+		if err := linkDao.Update(link); err != nil {
+			return nil, nil, fmt.Errorf("error updating %s user %s", id.Provider, err.Error())
+		}
+		// a stale profile is no reason to refuse the login
+		if borgUser, err = syncProfile(e.db, borgUser, id); err != nil {
+			reqlog.Warnf(ctx, "[Endpoints.Login] unable to sync profile of user %s: %s", borgUser.Id, err.Error())
+		}
+	}
+	if id.Provider == identity.Github {
+		if err := e.syncGithubLogin(ctx, borgUser.Id, id.Token); err != nil {
+			reqlog.Warnf(ctx, "[Endpoints.Login] unable to sync github organizations of user %s: %s",
+				borgUser.Id, err.Error())
+		}
 	}
 
-	// then just need to try to get the access token
-	tokenDao := domain.NewAccessTokenDao(e.db)
-	token, err := tokenDao.GetByToken(tkn.AccessToken)
+	// session tokens can do everything the user can do
+	expiresAt := time.Now().Add(e.tokenTtl)
+	token, err := issueAccessToken(e.db, domain.AccessToken{
+		UserId:    borgUser.Id,
+		Scopes:    domain.AllScopes,
+		ExpiresAt: &expiresAt,
+	})
 	if err != nil {
-		// token do not exist in db, just create it
-		token = domain.AccessToken{
-			Id:        uuid.NewV4().String(),
-			Token:     tkn.AccessToken,
-			UserId:    borgUser.Id,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
-		if err := tokenDao.Create(token); err != nil {
-			return nil, nil, fmt.Errorf("error creating token for user: %s", err.Error())
-		}
+		return nil, nil, err
 	}
+	return &borgUser, token, nil
+}
 
-	return &borgUser, &token, nil
+func registerUser(tx *gorm.DB, user domain.User, link domain.IdentityLink) error {
+	userDao := domain.NewUserDao(tx)
+	// an account renamed at the provider may still hold the login
+	if err := userDao.ReleaseLogin(user.Login, user.AccountType, user.Id); err != nil {
+		return fmt.Errorf("error releasing login %s", err.Error())
+	}
+	if err := userDao.Create(user); err != nil {
+		return fmt.Errorf("error creating new user %s", err.Error())
+	}
+	if err := domain.NewIdentityLinkDao(tx).Create(link); err != nil {
+		return fmt.Errorf("error creating new %s user %s", link.Provider, err.Error())
+	}
+	return nil
+}
+
+// issueAccessToken generates and saves a new borg access token from the template,
+// the returned token is the only place where its clear value exists
+func issueAccessToken(db *gorm.DB, token domain.AccessToken) (*domain.AccessToken, error) {
+	raw, err := secrets.NewToken()
+	if err != nil {
+		return nil, fmt.Errorf("error generating token: %s", err.Error())
+	}
+	token.Id = uuid.NewV4().String()
+	token.TokenHash = secrets.HashToken(raw)
+	token.CreatedAt = time.Now()
+	token.UpdatedAt = time.Now()
+	if err := domain.NewAccessTokenDao(db).Create(token); err != nil {
+		return nil, fmt.Errorf("error creating token for user: %s", err.Error())
+	}
+	token.Token = raw
+	return &token, nil
 }
 
 // GetUser by token
@@ -141,8 +283,8 @@ func (e *Endpoints) GetUser(token string) (*domain.User, error) {
 	// first get token
 	tokenDao := domain.NewAccessTokenDao(e.db)
 	t, err := tokenDao.GetByToken(token)
-	if err != nil {
-		return nil, fmt.Errorf("token (%s) is associated to no users", token)
+	if err != nil || t.IsExpired() {
+		return nil, domain.Unauthorizedf("token is associated to no users")
 	}
 	userDao := domain.NewUserDao(e.db)
 	u, _ := userDao.GetById(t.UserId)
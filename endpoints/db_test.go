@@ -0,0 +1,102 @@
+package endpoints
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	"github.com/ok-borg/api/domain"
+	"github.com/satori/go.uuid"
+)
+
+const testDatabase = "borg_test"
+
+// testDb recreates the borg_test database from the migrations on the mysql server of
+// BORG_TEST_MYSQL, a dsn without database such as root:root@tcp(127.0.0.1:3306)/.
+// The tests needing it are skipped when it is not set.
+func testDb(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("BORG_TEST_MYSQL")
+	if dsn == "" {
+		t.Skip("BORG_TEST_MYSQL is not set")
+	}
+	server, err := gorm.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if err := server.Exec("DROP DATABASE IF EXISTS " + testDatabase).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Exec("CREATE DATABASE " + testDatabase).Error; err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open("mysql", dsn+testDatabase+"?parseTime=True")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range migrationStatements(t) {
+		if err := db.Exec(stmt).Error; err != nil {
+			db.Close()
+			t.Fatalf("migration failed: %v\n%s", err, stmt)
+		}
+	}
+	return db
+}
+
+// migrationStatements returns the statements of the sql migrations in order,
+// without the creation and the selection of the borg database
+func migrationStatements(t *testing.T) []string {
+	files, err := filepath.Glob("../migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	number := func(f string) int {
+		n, _ := strconv.Atoi(strings.SplitN(filepath.Base(f), "_", 2)[0])
+		return n
+	}
+	sort.Slice(files, func(i, j int) bool { return number(files[i]) < number(files[j]) })
+	stmts := []string{}
+	for _, f := range files {
+		if number(f) == 0 {
+			continue
+		}
+		raw, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := []string{}
+		for _, l := range strings.Split(string(raw), "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(l), "--") {
+				lines = append(lines, l)
+			}
+		}
+		for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+			stmt = strings.TrimSpace(stmt)
+			if stmt != "" && stmt != "USE borg" {
+				stmts = append(stmts, stmt)
+			}
+		}
+	}
+	return stmts
+}
+
+// testUser creates a github user
+func testUser(t *testing.T, db *gorm.DB, login string) domain.User {
+	u := domain.User{
+		Id:          uuid.NewV4().String(),
+		Login:       login,
+		Name:        login,
+		Email:       login + "@example.com",
+		AccountType: domain.AccountTypeGithub,
+	}
+	if err := domain.NewUserDao(db).Create(u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
@@ -0,0 +1,312 @@
+package endpoints
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ok-borg/api/ctxext"
+	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/identity"
+	"github.com/ok-borg/api/reqlog"
+	"github.com/satori/go.uuid"
+	"golang.org/x/oauth2"
+)
+
+// LinkGithubOrganization makes the members of a github organization, or of one of its teams,
+// members of a borg organization. The user linking them must be an admin of both.
+func (e Endpoints) LinkGithubOrganization(
+	ctx context.Context,
+	db *gorm.DB,
+	userId string,
+	organizationId string,
+	githubOrg string,
+	githubTeam string,
+) (*domain.Organization, error) {
+	githubOrg = strings.ToLower(strings.TrimSpace(githubOrg))
+	githubTeam = strings.ToLower(strings.TrimSpace(githubTeam))
+	if githubOrg == "" {
+		return nil, domain.Invalidf("missing github organization")
+	}
+	uo, err := requireMember(db, userId, organizationId)
+	if err != nil {
+		return nil, err
+	}
+	if !uo.IsAdmin() {
+		return nil, domain.Forbiddenf(
+			"user (id=%s) is not administrator of organization (id=%s)",
+			userId, organizationId)
+	}
+	ms, err := e.githubMemberships(ctx, db, userId)
+	if err != nil {
+		return nil, err
+	}
+	admin := false
+	for _, m := range ms {
+		admin = admin || (m.Organization == githubOrg && m.Admin)
+	}
+	if !admin {
+		return nil, domain.Forbiddenf("user (id=%s) is not an owner of the github organization %s", userId, githubOrg)
+	}
+
+	organizationDao := domain.NewOrganizationDao(db)
+	o, err := organizationDao.GetById(organizationId)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireLive(o); err != nil {
+		return nil, err
+	}
+	o.GithubOrg = githubOrg
+	o.GithubTeam = githubTeam
+	o.UpdatedAt = time.Now()
+	o.UpdatedBy = userId
+	err = withAudit(db, domain.AuditEvent{
+		OrganizationId: organizationId,
+		ActorId:        userId,
+		Action:         domain.AuditOrgGithubLink,
+		Details:        strings.TrimSuffix(githubOrg+"/"+githubTeam, "/"),
+	}, func(tx *gorm.DB) error {
+		return domain.NewOrganizationDao(tx).Update(o)
+	})
+	if err != nil {
+		return nil, err
+	}
+	reqlog.Infof(ctx, "organization %s linked to github organization %s (team %q) by %s",
+		organizationId, githubOrg, githubTeam, userId)
+
+	// the linking user gets in sync right away, the others at their next login or sync
+	if err := syncGithubMemberships(db, userId, ms); err != nil {
+		reqlog.Warnf(ctx, "[Endpoints.LinkGithubOrganization] unable to sync user %s: %s", userId, err.Error())
+	}
+	return &o, nil
+}
+
+// UnlinkGithubOrganization stops syncing the members of an organization from github,
+// the synced members stay as regular members
+func (e Endpoints) UnlinkGithubOrganization(
+	ctx context.Context,
+	db *gorm.DB,
+	userId string,
+	organizationId string,
+) (*domain.Organization, error) {
+	uo, err := requireMember(db, userId, organizationId)
+	if err != nil {
+		return nil, err
+	}
+	if !uo.IsAdmin() {
+		return nil, domain.Forbiddenf(
+			"user (id=%s) is not administrator of organization (id=%s)",
+			userId, organizationId)
+	}
+	organizationDao := domain.NewOrganizationDao(db)
+	o, err := organizationDao.GetById(organizationId)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireLive(o); err != nil {
+		return nil, err
+	}
+	if o.GithubOrg == "" {
+		return nil, domain.NotFoundf("organization (id=%s) is not linked to github", organizationId)
+	}
+	o.GithubOrg = ""
+	o.GithubTeam = ""
+	o.UpdatedAt = time.Now()
+	o.UpdatedBy = userId
+
+	tx := db.Begin()
+	if err := domain.NewOrganizationDao(tx).Update(o); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := domain.NewUserOrganizationDao(tx).DetachSource(organizationId); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	err = recordAudit(tx, domain.AuditEvent{
+		OrganizationId: organizationId,
+		ActorId:        userId,
+		Action:         domain.AuditOrgGithubUnlink,
+	})
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	reqlog.Infof(ctx, "organization %s unlinked from github by %s", organizationId, userId)
+	return &o, nil
+}
+
+// SyncGithubOrganizations syncs the active users and the users synced before,
+// so the ones who left github organizations leave the borg ones
+func (e *Endpoints) SyncGithubOrganizations(ctx context.Context) {
+	start := time.Now()
+	linked, err := domain.NewOrganizationDao(e.db).ListLinkedToGithub()
+	if err != nil {
+		reqlog.Errorf(ctx, "[Endpoints.SyncGithubOrganizations] unable to list linked organizations: %s", err.Error())
+		return
+	}
+	synced, err := domain.NewUserOrganizationDao(e.db).ListUserIdsBySource(identity.Github)
+	if err != nil {
+		reqlog.Errorf(ctx, "[Endpoints.SyncGithubOrganizations] unable to list synced users: %s", err.Error())
+		return
+	}
+	if len(linked) == 0 && len(synced) == 0 {
+		return
+	}
+	active, err := domain.NewAccessTokenDao(e.db).ListActiveUserIds(start.Add(-profileSyncActiveFor))
+	if err != nil {
+		reqlog.Errorf(ctx, "[Endpoints.SyncGithubOrganizations] unable to list active users: %s", err.Error())
+		return
+	}
+	seen := map[string]bool{}
+	done := 0
+	for _, userId := range append(synced, active...) {
+		if seen[userId] {
+			continue
+		}
+		seen[userId] = true
+		ms, err := e.githubMemberships(ctx, e.db, userId)
+		if err != nil {
+			// without a github identity or a valid token the memberships stay as they are
+			reqlog.Debugf(ctx, "[Endpoints.SyncGithubOrganizations] skipping user %s: %s", userId, err.Error())
+			continue
+		}
+		if err := syncGithubMemberships(e.db, userId, ms); err != nil {
+			reqlog.Warnf(ctx, "[Endpoints.SyncGithubOrganizations] unable to sync user %s: %s", userId, err.Error())
+			continue
+		}
+		done++
+	}
+	reqlog.Infof(ctx, "%d/%d users synced with github organizations in %v", done, len(seen), time.Since(start))
+}
+
+// SyncGithubOrganizationsEvery runs SyncGithubOrganizations forever
+func (e *Endpoints) SyncGithubOrganizationsEvery(interval time.Duration) {
+	ctx := ctxext.WithRequestId(context.Background(), "github-org-sync")
+	for range time.Tick(interval) {
+		e.SyncGithubOrganizations(ctx)
+	}
+}
+
+// syncGithubLogin syncs the organizations of a user logging in with github
+func (e *Endpoints) syncGithubLogin(ctx context.Context, userId string, tkn *oauth2.Token) error {
+	p, ok := e.providers[identity.Github].(identity.OrganizationsProvider)
+	if !ok {
+		return nil
+	}
+	ms, err := p.Memberships(ctx, tkn)
+	if err != nil {
+		return err
+	}
+	return syncGithubMemberships(e.db, userId, ms)
+}
+
+// githubMemberships reads the github organizations of a user with the token of its github identity
+func (e *Endpoints) githubMemberships(ctx context.Context, db *gorm.DB, userId string) ([]identity.Membership, error) {
+	provider := e.providers[identity.Github]
+	p, ok := provider.(identity.OrganizationsProvider)
+	if !ok {
+		return nil, domain.NotFoundf("github login is not enabled")
+	}
+	links, err := domain.NewIdentityLinkDao(db).ListByBorgUser(userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		if link.Provider != identity.Github {
+			continue
+		}
+		tkn, err := linkToken(ctx, db, provider, link)
+		if err != nil {
+			return nil, err
+		}
+		return p.Memberships(ctx, tkn)
+	}
+	return nil, domain.NotFoundf("user (id=%s) has no github identity", userId)
+}
+
+// syncGithubMemberships adds the user to the organizations linked to its github ones
+// and removes it from the ones it was synced in and left on github.
+// Owners and the memberships managed in borg are never touched.
+func syncGithubMemberships(db *gorm.DB, userId string, ms []identity.Membership) error {
+	linked, err := domain.NewOrganizationDao(db).ListLinkedToGithub()
+	if err != nil {
+		return err
+	}
+	byOrg := map[string]identity.Membership{}
+	for _, m := range ms {
+		byOrg[m.Organization] = m
+	}
+	tx := db.Begin()
+	userOrganizationDao := domain.NewUserOrganizationDao(tx)
+	for _, o := range linked {
+		role, entitled := githubRole(o, byOrg)
+		uo, err := userOrganizationDao.GetByUserAndOrganization(userId, o.Id)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			tx.Rollback()
+			return err
+		}
+		event := domain.AuditEvent{OrganizationId: o.Id, ActorId: userId, TargetId: userId, Details: "synced from github"}
+		switch {
+		case err == gorm.ErrRecordNotFound && entitled:
+			event.Action = domain.AuditMemberJoin
+			err = userOrganizationDao.Create(domain.UserOrganization{
+				Id:             uuid.NewV4().String(),
+				UserId:         userId,
+				OrganizationId: o.Id,
+				Role:           role,
+				Source:         identity.Github,
+				CreatedAt:      time.Now(),
+				UpdatedAt:      time.Now(),
+				CreatedBy:      userId,
+				UpdatedBy:      userId,
+			})
+		case err == gorm.ErrRecordNotFound, uo.Source != identity.Github, uo.Role == domain.RoleOwner:
+			err = nil
+		case !entitled:
+			event.Action = domain.AuditMemberLeave
+			err = userOrganizationDao.Delete(uo.Id)
+		case uo.Role != role:
+			event.Action = domain.AuditMemberRole
+			event.Details = uo.Role + " -> " + role + ", synced from github"
+			uo.Role = role
+			uo.UpdatedAt = time.Now()
+			uo.UpdatedBy = userId
+			err = userOrganizationDao.Update(uo)
+		}
+		if err == nil && event.Action != "" {
+			err = recordAudit(tx, event)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// githubRole tells if the github memberships give access to the organization and with which role,
+// github organization owners are admins
+func githubRole(o domain.Organization, byOrg map[string]identity.Membership) (string, bool) {
+	m, ok := byOrg[o.GithubOrg]
+	if !ok {
+		return "", false
+	}
+	if m.Admin {
+		return domain.RoleAdmin, true
+	}
+	if o.GithubTeam == "" {
+		return domain.RoleEditor, true
+	}
+	for _, t := range m.Teams {
+		if t == o.GithubTeam {
+			return domain.RoleEditor, true
+		}
+	}
+	return "", false
+}
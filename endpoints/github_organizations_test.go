@@ -0,0 +1,200 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/identity"
+	"github.com/ok-borg/api/secrets"
+	"github.com/satori/go.uuid"
+	"golang.org/x/oauth2"
+)
+
+// fakeGithub serves the organizations and the teams of the users by access token
+type fakeGithub struct {
+	mtx sync.Mutex
+	// orgs is the role of the user in each github organization, member or admin
+	orgs  map[string]map[string]string
+	teams map[string]map[string][]string
+}
+
+func (f *fakeGithub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	tkn := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/user/memberships/orgs":
+		ms := []map[string]interface{}{}
+		for org, role := range f.orgs[tkn] {
+			ms = append(ms, map[string]interface{}{
+				"state":        "active",
+				"role":         role,
+				"organization": map[string]string{"login": org},
+			})
+		}
+		json.NewEncoder(w).Encode(ms)
+	case "/user/teams":
+		ts := []map[string]interface{}{}
+		for org, slugs := range f.teams[tkn] {
+			for _, slug := range slugs {
+				ts = append(ts, map[string]interface{}{
+					"slug":         slug,
+					"organization": map[string]string{"login": org},
+				})
+			}
+		}
+		json.NewEncoder(w).Encode(ts)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeGithub) set(tkn string, orgs map[string]string, teams map[string][]string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.orgs[tkn] = orgs
+	f.teams[tkn] = teams
+}
+
+// githubUser creates a user with a github identity whose token is tkn-<login>
+func githubUser(t *testing.T, db *gorm.DB, login string) domain.User {
+	u := testUser(t, db, login)
+	link := domain.IdentityLink{
+		Id:             uuid.NewV4().String(),
+		Provider:       identity.Github,
+		ProviderUserId: login,
+		BorgUserId:     u.Id,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	if err := setLinkToken(&link, &oauth2.Token{AccessToken: "tkn-" + login}); err != nil {
+		t.Fatal(err)
+	}
+	if err := domain.NewIdentityLinkDao(db).Create(link); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// roleIn returns the role of the user in the organization, empty when not a member
+func roleIn(t *testing.T, db *gorm.DB, userId string, organizationId string) string {
+	uo, err := domain.NewUserOrganizationDao(db).GetByUserAndOrganization(userId, organizationId)
+	if err == gorm.ErrRecordNotFound {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uo.Role
+}
+
+func TestSyncGithubOrganizations(t *testing.T) {
+	db := testDb(t)
+	defer db.Close()
+	if err := secrets.Init(strings.Repeat("ab", 32)); err != nil {
+		t.Fatal(err)
+	}
+	gh := &fakeGithub{orgs: map[string]map[string]string{}, teams: map[string]map[string][]string{}}
+	srv := httptest.NewServer(gh)
+	defer srv.Close()
+	provider, err := identity.NewGithubProvider("client", "secret", "http://borg.test/callback", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Endpoints{db: db, providers: identity.Providers{}}
+	e.providers.Add(provider)
+	ctx := context.Background()
+	alice, bob, carol, dave := githubUser(t, db, "alice"), githubUser(t, db, "bob"),
+		githubUser(t, db, "carol"), githubUser(t, db, "dave")
+	gh.set("tkn-alice", map[string]string{"acme": "admin"}, nil)
+	gh.set("tkn-bob", map[string]string{"acme": "member"}, map[string][]string{"acme": {"devs"}})
+	gh.set("tkn-carol", map[string]string{"acme": "member"}, nil)
+
+	// alice links acme to all of github acme and acme-devs to its devs team
+	acme, err := e.CreateOrganization(ctx, db, alice.Id, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	devs, err := e.CreateOrganization(ctx, db, alice.Id, "acme-devs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.LinkGithubOrganization(ctx, db, bob.Id, acme.Id, "acme", ""); domain.Kind(err) != domain.ErrForbidden {
+		t.Fatalf("a non member linked the organization, error: %v", err)
+	}
+	if _, err := e.LinkGithubOrganization(ctx, db, alice.Id, acme.Id, "acme", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.LinkGithubOrganization(ctx, db, alice.Id, devs.Id, "Acme", "Devs"); err != nil {
+		t.Fatal(err)
+	}
+	// dave is added by hand
+	err = domain.NewUserOrganizationDao(db).Create(domain.UserOrganization{
+		Id:             uuid.NewV4().String(),
+		UserId:         dave.Id,
+		OrganizationId: acme.Id,
+		Role:           domain.RoleEditor,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+		CreatedBy:      alice.Id,
+		UpdatedBy:      alice.Id,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the members join as editors when they log in, the team restricts acme-devs
+	for _, u := range []domain.User{bob, carol} {
+		if err := e.syncGithubLogin(ctx, u.Id, &oauth2.Token{AccessToken: "tkn-" + u.Login}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectRoles := func(step string, expected map[string]map[string]string) {
+		for orgId, roles := range expected {
+			for userId, role := range roles {
+				if actual := roleIn(t, db, userId, orgId); actual != role {
+					t.Errorf("%s: user %s is %q in organization %s instead of %q", step, userId, actual, orgId, role)
+				}
+			}
+		}
+	}
+	expectRoles("login", map[string]map[string]string{
+		acme.Id: {alice.Id: domain.RoleOwner, bob.Id: domain.RoleEditor, carol.Id: domain.RoleEditor, dave.Id: domain.RoleEditor},
+		devs.Id: {alice.Id: domain.RoleOwner, bob.Id: domain.RoleEditor, carol.Id: ""},
+	})
+
+	// carol becomes an owner of github acme, bob leaves it, alice and dave are not on it anymore
+	gh.set("tkn-carol", map[string]string{"acme": "admin"}, nil)
+	gh.set("tkn-bob", nil, nil)
+	gh.set("tkn-alice", nil, nil)
+	e.SyncGithubOrganizations(ctx)
+	for _, u := range []domain.User{alice, dave} {
+		if err := e.syncGithubLogin(ctx, u.Id, &oauth2.Token{AccessToken: "tkn-" + u.Login}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectRoles("sync", map[string]map[string]string{
+		acme.Id: {alice.Id: domain.RoleOwner, bob.Id: "", carol.Id: domain.RoleAdmin, dave.Id: domain.RoleEditor},
+		devs.Id: {alice.Id: domain.RoleOwner, bob.Id: "", carol.Id: domain.RoleAdmin},
+	})
+
+	// unlinking keeps the synced members as regular ones
+	if _, err := e.UnlinkGithubOrganization(ctx, db, alice.Id, acme.Id); err != nil {
+		t.Fatal(err)
+	}
+	gh.set("tkn-carol", nil, nil)
+	e.SyncGithubOrganizations(ctx)
+	expectRoles("unlink", map[string]map[string]string{
+		acme.Id: {carol.Id: domain.RoleAdmin},
+		devs.Id: {carol.Id: ""},
+	})
+}
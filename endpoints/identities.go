@@ -0,0 +1,210 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/reqlog"
+	"github.com/satori/go.uuid"
+)
+
+// ListIdentities returns the provider identities linked to the user
+func (e Endpoints) ListIdentities(db *gorm.DB, userId string) ([]domain.IdentityLink, error) {
+	return domain.NewIdentityLinkDao(db).ListByBorgUser(userId)
+}
+
+// LinkIdentity exchanges a provider code and links the identity to the user.
+// An identity already linked to another account is only taken when merge is set,
+// the other account is then merged into the one of the user.
+func (e *Endpoints) LinkIdentity(
+	ctx context.Context,
+	db *gorm.DB,
+	userId string,
+	providerName string,
+	code string,
+	state string,
+	codeVerifier string,
+	merge bool,
+) (*domain.IdentityLink, error) {
+	id, err := e.exchange(ctx, providerName, code, state, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	linkDao := domain.NewIdentityLinkDao(db)
+	link, err := linkDao.Get(domain.IdentityLink{
+		Provider:       id.Provider,
+		Issuer:         id.Issuer,
+		ProviderUserId: id.Id,
+	})
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, fmt.Errorf("error getting %s user %s", id.Provider, err.Error())
+	}
+	if err == gorm.ErrRecordNotFound {
+		link = domain.IdentityLink{
+			Id:             uuid.NewV4().String(),
+			Provider:       id.Provider,
+			Issuer:         id.Issuer,
+			ProviderUserId: id.Id,
+			BorgUserId:     userId,
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+		}
+		if err := setLinkToken(&link, id.Token); err != nil {
+			return nil, err
+		}
+		if err := linkDao.Create(link); err != nil {
+			return nil, fmt.Errorf("error creating new %s user %s", id.Provider, err.Error())
+		}
+		reqlog.Infof(ctx, "%s identity %s linked to user %s", id.Provider, link.Id, userId)
+		return &link, nil
+	}
+
+	if link.BorgUserId != userId {
+		if !merge {
+			return nil, domain.Conflictf(
+				"this %s identity belongs to another borg account, link it with merge to merge both accounts",
+				id.Provider)
+		}
+		if err := e.mergeUsers(ctx, db, link.BorgUserId, userId); err != nil {
+			return nil, err
+		}
+		link.BorgUserId = userId
+	}
+	if err := setLinkToken(&link, id.Token); err != nil {
+		return nil, err
+	}
+	link.UpdatedAt = time.Now()
+	if err := linkDao.Update(link); err != nil {
+		return nil, fmt.Errorf("error updating %s user %s", id.Provider, err.Error())
+	}
+	return &link, nil
+}
+
+// UnlinkIdentity removes an identity from the user,
+// the last one is kept so the user can still log in
+func (e Endpoints) UnlinkIdentity(
+	ctx context.Context,
+	db *gorm.DB,
+	userId string,
+	providerName string,
+	linkId string,
+) error {
+	tx := db.Begin()
+	if err := unlinkIdentity(tx, userId, providerName, linkId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	reqlog.Infof(ctx, "%s identity %s unlinked from user %s", providerName, linkId, userId)
+	return nil
+}
+
+func unlinkIdentity(tx *gorm.DB, userId string, providerName string, linkId string) error {
+	// lock the user so two unlinks cannot remove the last two identities
+	user := domain.User{}
+	err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("users.id = ?", userId).First(&user).Error
+	if err != nil {
+		return err
+	}
+	linkDao := domain.NewIdentityLinkDao(tx)
+	links, err := linkDao.ListByBorgUser(userId)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, l := range links {
+		if l.Provider == providerName && l.Id == linkId {
+			found = true
+		}
+	}
+	if !found {
+		return domain.NotFoundf("no %s identity (id=%s) linked to this account", providerName, linkId)
+	}
+	if len(links) == 1 {
+		return domain.Conflictf("cannot unlink the last identity of an account")
+	}
+	return linkDao.Delete(providerName, linkId)
+}
+
+// mergeUsers moves everything owned by fromUserId to toUserId then deletes fromUserId
+func (e Endpoints) mergeUsers(ctx context.Context, db *gorm.DB, fromUserId string, toUserId string) error {
+	// snippets first, if it fails no account was touched and the merge can be retried
+	if err := e.reassignSnippets(ctx, fromUserId, toUserId); err != nil {
+		reqlog.Errorf(ctx, "[Endpoints.mergeUsers] unable to reassign snippets: %s", err.Error())
+		return err
+	}
+	tx := db.Begin()
+	if err := mergeUserRows(tx, fromUserId, toUserId); err != nil {
+		tx.Rollback()
+		reqlog.Errorf(ctx, "[Endpoints.mergeUsers] unable to merge user %s into %s: %s",
+			fromUserId, toUserId, err.Error())
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	reqlog.Infof(ctx, "user %s merged into %s", fromUserId, toUserId)
+	return nil
+}
+
+func mergeUserRows(tx *gorm.DB, fromUserId string, toUserId string) error {
+	// memberships, when both users are members the strongest right is kept
+	userOrganizationDao := domain.NewUserOrganizationDao(tx)
+	uos, err := userOrganizationDao.ListByUser(fromUserId)
+	if err != nil {
+		return err
+	}
+	for _, uo := range uos {
+		existing, err := userOrganizationDao.GetByUserAndOrganization(toUserId, uo.OrganizationId)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		if err == gorm.ErrRecordNotFound {
+			uo.UserId = toUserId
+			uo.UpdatedAt = time.Now()
+			if err := userOrganizationDao.Update(uo); err != nil {
+				return err
+			}
+			continue
+		}
+		if domain.RoleAbove(uo.Role, existing.Role) {
+			existing.Role = uo.Role
+			existing.UpdatedAt = time.Now()
+			if err := userOrganizationDao.Update(existing); err != nil {
+				return err
+			}
+		}
+		if err := userOrganizationDao.Delete(uo.Id); err != nil {
+			return err
+		}
+	}
+	if err := userOrganizationDao.ReassignUser(fromUserId, toUserId); err != nil {
+		return err
+	}
+	if err := domain.NewOrganizationDao(tx).ReassignUser(fromUserId, toUserId); err != nil {
+		return err
+	}
+	if err := domain.NewOrganizationJoinLinkDao(tx).ReassignUser(fromUserId, toUserId); err != nil {
+		return err
+	}
+	if err := domain.NewOrganizationInvitationDao(tx).ReassignUser(fromUserId, toUserId); err != nil {
+		return err
+	}
+	if err := domain.NewAuditEventDao(tx).ReassignUser(fromUserId, toUserId); err != nil {
+		return err
+	}
+	if err := domain.NewIdentityLinkDao(tx).ReassignBorgUser(fromUserId, toUserId); err != nil {
+		return err
+	}
+	// the sessions and tokens of the merged account die with it
+	if err := domain.NewAccessTokenDao(tx).DeleteByUser(fromUserId); err != nil {
+		return err
+	}
+	return domain.NewUserDao(tx).Delete(fromUserId)
+}
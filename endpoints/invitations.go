@@ -0,0 +1,261 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/notify"
+	"github.com/ok-borg/api/reqlog"
+	"github.com/satori/go.uuid"
+)
+
+// InviteToOrganization invites a github login or an email address to an organization.
+// The invitee is notified by mail when its address is known.
+func (e Endpoints) InviteToOrganization(
+	ctx context.Context,
+	db *gorm.DB,
+	userId string,
+	organizationId string,
+	login string,
+	email string,
+	role string,
+) (*domain.OrganizationInvitation, error) {
+	login, email = strings.TrimSpace(login), strings.TrimSpace(email)
+	if (login == "") == (email == "") {
+		return nil, domain.Invalidf("invite either a github login or an email address")
+	}
+	if email != "" && !strings.Contains(email, "@") {
+		return nil, domain.Invalidf("invalid email address %s", email)
+	}
+	if role == "" {
+		role = domain.RoleEditor
+	}
+	if !domain.ValidRole(role) || role == domain.RoleOwner {
+		return nil, domain.Invalidf("invitations cannot give the role %s", role)
+	}
+	uo, err := requireMember(db, userId, organizationId)
+	if err != nil {
+		return nil, err
+	}
+	if !uo.IsAdmin() {
+		return nil, domain.Forbiddenf(
+			"user (id=%s) is not administrator of organization (id=%s)",
+			userId, organizationId)
+	}
+	if !domain.RoleAtLeast(uo.Role, role) {
+		return nil, domain.Forbiddenf("a %s cannot grant the %s role", uo.Role, role)
+	}
+	o, err := domain.NewOrganizationDao(db).GetById(organizationId)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireLive(o); err != nil {
+		return nil, err
+	}
+
+	invitationDao := domain.NewOrganizationInvitationDao(db)
+	if _, err := invitationDao.GetPending(organizationId, login, email); err == nil {
+		return nil, domain.Conflictf("%s%s is already invited to organization (id=%s)", login, email, organizationId)
+	}
+	invitee, err := inviteeOf(db, login, email)
+	if err != nil {
+		return nil, err
+	}
+	if invitee != nil {
+		if _, err := domain.NewUserOrganizationDao(db).GetByUserAndOrganization(invitee.Id, organizationId); err == nil {
+			return nil, domain.Conflictf("%s%s is already a member of organization (id=%s)", login, email, organizationId)
+		}
+	}
+
+	i := domain.OrganizationInvitation{
+		Id:             uuid.NewV4().String(),
+		OrganizationId: organizationId,
+		Login:          login,
+		Email:          email,
+		Role:           role,
+		Status:         domain.InvitationPending,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+		CreatedBy:      userId,
+		UpdatedBy:      userId,
+	}
+	err = withAudit(db, domain.AuditEvent{
+		OrganizationId: organizationId,
+		ActorId:        userId,
+		Action:         domain.AuditInvitationCreate,
+		TargetId:       i.Id,
+		Details:        login + email + " as " + role,
+	}, func(tx *gorm.DB) error {
+		return domain.NewOrganizationInvitationDao(tx).Create(i)
+	})
+	if err != nil {
+		reqlog.Errorf(ctx, "[Endpoints.InviteToOrganization] unable to invite %s%s to organization %s: %s",
+			login, email, organizationId, err.Error())
+		return nil, err
+	}
+	reqlog.Infof(ctx, "%s%s invited to organization %s by %s", login, email, organizationId, userId)
+
+	// the invitation is there whatever happens to the mail
+	to := email
+	if to == "" && invitee != nil {
+		to = invitee.Email
+	}
+	if to != "" {
+		err := e.notifier.Notify(ctx, notify.Message{
+			To:      to,
+			Subject: fmt.Sprintf("You are invited to join %s on borg", o.Name),
+			Body: fmt.Sprintf("You are invited to join the organization %s on borg as %s.\n\n"+
+				"Log in to borg to accept or decline the invitation.\n", o.Name, role),
+		})
+		if err != nil {
+			reqlog.Warnf(ctx, "[Endpoints.InviteToOrganization] unable to notify %s of invitation %s: %s",
+				to, i.Id, err.Error())
+		}
+	}
+	return &i, nil
+}
+
+// inviteeOf returns the user invited as login or email, nil when there is none yet
+func inviteeOf(db *gorm.DB, login string, email string) (*domain.User, error) {
+	userDao := domain.NewUserDao(db)
+	var u domain.User
+	var err error
+	if login != "" {
+		// invited logins are github ones
+		u, err = userDao.GetByLoginAndAccountType(login, domain.AccountTypeGithub)
+	} else {
+		u, err = userDao.GetByEmail(email)
+	}
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ListInvitations returns the pending invitations of a user
+func (e Endpoints) ListInvitations(db *gorm.DB, userId string) ([]domain.OrganizationInvitation, error) {
+	u, err := domain.NewUserDao(db).GetById(userId)
+	if err != nil {
+		return nil, err
+	}
+	// logins are only invited as github logins
+	login := ""
+	if u.AccountType == domain.AccountTypeGithub {
+		login = u.Login
+	}
+	return domain.NewOrganizationInvitationDao(db).ListPendingFor(login, u.Email)
+}
+
+// AcceptInvitation makes the user a member of the organization it is invited to
+func (e Endpoints) AcceptInvitation(
+	ctx context.Context,
+	db *gorm.DB,
+	userId string,
+	invitationId string,
+) (*domain.UserOrganization, error) {
+	tx := db.Begin()
+	uo, err := acceptInvitation(tx, userId, invitationId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	reqlog.Infof(ctx, "user %s accepted invitation %s", userId, invitationId)
+	return uo, nil
+}
+
+func acceptInvitation(tx *gorm.DB, userId string, invitationId string) (*domain.UserOrganization, error) {
+	i, err := pendingInvitation(tx, userId, invitationId)
+	if err != nil {
+		return nil, err
+	}
+	if o, err := lockOrganization(tx, i.OrganizationId); err != nil || o.IsDeleted() {
+		return nil, domain.NotFoundf("organization (id=%s) does not exist anymore", i.OrganizationId)
+	}
+	userOrganizationDao := domain.NewUserOrganizationDao(tx)
+	if _, err := userOrganizationDao.GetByUserAndOrganization(userId, i.OrganizationId); err == nil {
+		return nil, domain.Conflictf("you already joined this organization")
+	}
+	uo := domain.UserOrganization{
+		Id:             uuid.NewV4().String(),
+		UserId:         userId,
+		OrganizationId: i.OrganizationId,
+		Role:           i.Role,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+		CreatedBy:      i.CreatedBy,
+		UpdatedBy:      userId,
+	}
+	if err := userOrganizationDao.Create(uo); err != nil {
+		return nil, err
+	}
+	i.Status = domain.InvitationAccepted
+	i.UpdatedAt = time.Now()
+	i.UpdatedBy = userId
+	if err := domain.NewOrganizationInvitationDao(tx).Update(i); err != nil {
+		return nil, err
+	}
+	return &uo, recordAudit(tx, domain.AuditEvent{
+		OrganizationId: i.OrganizationId,
+		ActorId:        userId,
+		Action:         domain.AuditMemberJoin,
+		TargetId:       userId,
+		Details:        "invitation " + i.Id + " as " + i.Role,
+	})
+}
+
+// DeclineInvitation refuses an invitation, it cannot be accepted afterwards
+func (e Endpoints) DeclineInvitation(
+	ctx context.Context,
+	db *gorm.DB,
+	userId string,
+	invitationId string,
+) error {
+	tx := db.Begin()
+	i, err := pendingInvitation(tx, userId, invitationId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	i.Status = domain.InvitationDeclined
+	i.UpdatedAt = time.Now()
+	i.UpdatedBy = userId
+	if err := domain.NewOrganizationInvitationDao(tx).Update(i); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	reqlog.Infof(ctx, "user %s declined invitation %s", userId, invitationId)
+	return nil
+}
+
+// pendingInvitation locks a pending invitation for the user
+func pendingInvitation(tx *gorm.DB, userId string, invitationId string) (domain.OrganizationInvitation, error) {
+	u, err := domain.NewUserDao(tx).GetById(userId)
+	if err != nil {
+		return domain.OrganizationInvitation{}, err
+	}
+	i, err := domain.NewOrganizationInvitationDao(tx).GetForUpdate(invitationId)
+	// other users cannot tell the invitations of someone else exist
+	if err == gorm.ErrRecordNotFound || (err == nil && !i.IsFor(u)) {
+		return i, domain.NotFoundf("cannot find invitation (id=%s)", invitationId)
+	}
+	if err != nil {
+		return i, err
+	}
+	if i.Status != domain.InvitationPending {
+		return i, domain.Conflictf("invitation (id=%s) is already %s", invitationId, i.Status)
+	}
+	return i, nil
+}
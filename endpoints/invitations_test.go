@@ -0,0 +1,119 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/notify"
+)
+
+func TestInviteByEmail(t *testing.T) {
+	db := testDb(t)
+	defer db.Close()
+	dir, err := ioutil.TempDir("", "borg-mails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	e := Endpoints{db: db, notifier: notify.NewFileNotifier(dir)}
+	ctx := context.Background()
+	alice, bob, carol := testUser(t, db, "alice"), testUser(t, db, "bob"), testUser(t, db, "carol")
+	o, err := e.CreateOrganization(ctx, db, alice.Id, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := e.InviteToOrganization(ctx, db, alice.Id, o.Id, "", bob.Email, domain.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mails, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(mails) != 1 {
+		t.Fatalf("expected one mail, found %d", len(mails))
+	}
+	raw, err := ioutil.ReadFile(mails[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := notify.Message{}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.To != bob.Email {
+		t.Fatalf("the invitation was mailed to %s instead of %s", m.To, bob.Email)
+	}
+	if _, err := e.InviteToOrganization(ctx, db, alice.Id, o.Id, "", bob.Email, domain.RoleAdmin); domain.Kind(err) != domain.ErrConflict {
+		t.Fatalf("bob was invited twice, error: %v", err)
+	}
+
+	pending, err := e.ListInvitations(db, bob.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Id != i.Id {
+		t.Fatalf("bob does not see the invitation: %+v", pending)
+	}
+	if _, err := e.AcceptInvitation(ctx, db, carol.Id, i.Id); domain.Kind(err) != domain.ErrNotFound {
+		t.Fatalf("carol accepted the invitation of bob, error: %v", err)
+	}
+
+	uo, err := e.AcceptInvitation(ctx, db, bob.Id, i.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uo.OrganizationId != o.Id || uo.Role != domain.RoleAdmin {
+		t.Fatalf("unexpected membership %+v", uo)
+	}
+	if _, err := e.AcceptInvitation(ctx, db, bob.Id, i.Id); domain.Kind(err) != domain.ErrConflict {
+		t.Fatalf("the invitation was accepted twice, error: %v", err)
+	}
+	if _, err := e.AcceptInvitation(ctx, db, carol.Id, i.Id); domain.Kind(err) != domain.ErrNotFound {
+		t.Fatalf("carol can tell the invitation of bob exists, error: %v", err)
+	}
+}
+
+func TestDeclineInvitation(t *testing.T) {
+	db := testDb(t)
+	defer db.Close()
+	dir, err := ioutil.TempDir("", "borg-mails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	e := Endpoints{db: db, notifier: notify.NewFileNotifier(dir)}
+	ctx := context.Background()
+	alice, bob, carol := testUser(t, db, "alice"), testUser(t, db, "bob"), testUser(t, db, "carol")
+	o, err := e.CreateOrganization(ctx, db, alice.Id, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := e.InviteToOrganization(ctx, db, alice.Id, o.Id, "bob", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.DeclineInvitation(ctx, db, carol.Id, i.Id); domain.Kind(err) != domain.ErrNotFound {
+		t.Fatalf("carol declined the invitation of bob, error: %v", err)
+	}
+	if err := e.DeclineInvitation(ctx, db, bob.Id, i.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AcceptInvitation(ctx, db, bob.Id, i.Id); domain.Kind(err) != domain.ErrConflict {
+		t.Fatalf("a declined invitation was accepted, error: %v", err)
+	}
+	if _, err := domain.NewUserOrganizationDao(db).GetByUserAndOrganization(bob.Id, o.Id); err == nil {
+		t.Fatal("bob joined the organization after declining")
+	}
+	pending, err := e.ListInvitations(db, bob.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("a declined invitation is still pending: %+v", pending)
+	}
+}
@@ -0,0 +1,222 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ok-borg/api/ctxext"
+	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/reqlog"
+	"github.com/ok-borg/api/secrets"
+)
+
+// how long the owner has to confirm the deletion of an organization
+const organizationDeletionTokenTtl = 10 * time.Minute
+
+// OrganizationDeletion is what the owner needs to confirm the deletion of an organization
+type OrganizationDeletion struct {
+	ConfirmationToken string
+	ExpiresAt         time.Time
+	// DeletesAt is when the organization would be deleted if confirmed now
+	DeletesAt time.Time
+}
+
+// RequestOrganizationDeletion returns the token confirming the deletion of an organization,
+// only its owner can get one
+func (e Endpoints) RequestOrganizationDeletion(
+	db *gorm.DB,
+	userId string,
+	organizationId string,
+) (*OrganizationDeletion, error) {
+	o, err := requireOwner(db, userId, organizationId)
+	if err != nil {
+		return nil, err
+	}
+	if o.IsDeleted() {
+		return nil, domain.Conflictf("organization (id=%s) is already deleted", organizationId)
+	}
+	expiresAt := time.Now().Add(organizationDeletionTokenTtl)
+	token, err := deletionToken(userId, organizationId, expiresAt.Unix())
+	if err != nil {
+		return nil, err
+	}
+	return &OrganizationDeletion{
+		ConfirmationToken: token,
+		ExpiresAt:         expiresAt,
+		DeletesAt:         time.Now().Add(e.organizationGracePeriod),
+	}, nil
+}
+
+// DeleteOrganization schedules the deletion of an organization after the grace period.
+// Until then its snippets are out of reach, and its owner can restore it.
+func (e Endpoints) DeleteOrganization(
+	ctx context.Context,
+	db *gorm.DB,
+	userId string,
+	organizationId string,
+	confirm string,
+) (*domain.Organization, error) {
+	o, err := requireOwner(db, userId, organizationId)
+	if err != nil {
+		return nil, err
+	}
+	if o.IsDeleted() {
+		return nil, domain.Conflictf("organization (id=%s) is already deleted", organizationId)
+	}
+	if err := verifyDeletionToken(confirm, userId, organizationId); err != nil {
+		return nil, err
+	}
+	deletesAt := time.Now().Add(e.organizationGracePeriod)
+	o.DeletesAt = &deletesAt
+	o.UpdatedAt = time.Now()
+	o.UpdatedBy = userId
+	err = withAudit(db, domain.AuditEvent{
+		OrganizationId: organizationId,
+		ActorId:        userId,
+		Action:         domain.AuditOrgDelete,
+		Details:        "purged at " + deletesAt.Format(time.RFC3339),
+	}, func(tx *gorm.DB) error {
+		return domain.NewOrganizationDao(tx).Update(o)
+	})
+	if err != nil {
+		return nil, err
+	}
+	reqlog.Infof(ctx, "organization %s deleted by %s, purged at %v", organizationId, userId, deletesAt)
+	return &o, nil
+}
+
+// RestoreOrganization cancels the deletion of an organization during its grace period
+func (e Endpoints) RestoreOrganization(
+	ctx context.Context,
+	db *gorm.DB,
+	userId string,
+	organizationId string,
+) (*domain.Organization, error) {
+	o, err := requireOwner(db, userId, organizationId)
+	if err != nil {
+		return nil, err
+	}
+	if !o.IsDeleted() {
+		return nil, domain.Conflictf("organization (id=%s) is not deleted", organizationId)
+	}
+	o.DeletesAt = nil
+	o.UpdatedAt = time.Now()
+	o.UpdatedBy = userId
+	err = withAudit(db, domain.AuditEvent{
+		OrganizationId: organizationId,
+		ActorId:        userId,
+		Action:         domain.AuditOrgRestore,
+	}, func(tx *gorm.DB) error {
+		return domain.NewOrganizationDao(tx).Update(o)
+	})
+	if err != nil {
+		return nil, err
+	}
+	reqlog.Infof(ctx, "organization %s restored by %s", organizationId, userId)
+	return &o, nil
+}
+
+// PurgeOrganizations deletes the organizations whose grace period is over,
+// with their memberships, join links and snippets
+func (e *Endpoints) PurgeOrganizations(ctx context.Context) {
+	orgs, err := domain.NewOrganizationDao(e.db).ListDeletable(time.Now())
+	if err != nil {
+		reqlog.Errorf(ctx, "[Endpoints.PurgeOrganizations] unable to list deleted organizations: %s", err.Error())
+		return
+	}
+	for _, o := range orgs {
+		tx := e.db.Begin()
+		if err := deleteOrganizationRows(tx, o.Id); err != nil {
+			tx.Rollback()
+			reqlog.Errorf(ctx, "[Endpoints.PurgeOrganizations] unable to delete organization %s: %s", o.Id, err.Error())
+			continue
+		}
+		if err := tx.Commit().Error; err != nil {
+			reqlog.Errorf(ctx, "[Endpoints.PurgeOrganizations] unable to delete organization %s: %s", o.Id, err.Error())
+			continue
+		}
+		// the rows are gone, a failure here is logged to be finished by hand
+		if err := e.dropIndex(o.Index()); err != nil {
+			reqlog.Errorf(ctx, "[Endpoints.PurgeOrganizations] unable to drop index %s of deleted organization %s: %s",
+				o.Index(), o.Id, err.Error())
+			continue
+		}
+		reqlog.Infof(ctx, "organization %s purged", o.Id)
+	}
+}
+
+// PurgeOrganizationsEvery runs PurgeOrganizations forever
+func (e *Endpoints) PurgeOrganizationsEvery(interval time.Duration) {
+	ctx := ctxext.WithRequestId(context.Background(), "organization-purge")
+	for range time.Tick(interval) {
+		e.PurgeOrganizations(ctx)
+	}
+}
+
+// deleteOrganizationRows deletes an organization, its memberships, join links, invitations and audit log
+func deleteOrganizationRows(tx *gorm.DB, organizationId string) error {
+	if err := domain.NewUserOrganizationDao(tx).DeleteByOrganization(organizationId); err != nil {
+		return err
+	}
+	if err := domain.NewOrganizationJoinLinkDao(tx).DeleteByOrganization(organizationId); err != nil {
+		return err
+	}
+	if err := domain.NewOrganizationInvitationDao(tx).DeleteByOrganization(organizationId); err != nil {
+		return err
+	}
+	if err := domain.NewAuditEventDao(tx).DeleteByOrganization(organizationId); err != nil {
+		return err
+	}
+	return domain.NewOrganizationDao(tx).Delete(organizationId)
+}
+
+// requireOwner returns the organization if the user owns it
+func requireOwner(db *gorm.DB, userId string, organizationId string) (domain.Organization, error) {
+	uo, err := requireMember(db, userId, organizationId)
+	if err != nil {
+		return domain.Organization{}, err
+	}
+	if uo.Role != domain.RoleOwner {
+		return domain.Organization{}, domain.Forbiddenf(
+			"user (id=%s) is not the owner of organization (id=%s)", userId, organizationId)
+	}
+	return domain.NewOrganizationDao(db).GetById(organizationId)
+}
+
+// deletionToken is the expiry and a signature binding it to the owner and the organization
+func deletionToken(userId string, organizationId string, expiresAt int64) (string, error) {
+	sig, err := secrets.Sign(deletionTokenPayload(userId, organizationId, expiresAt))
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(expiresAt, 10) + "." + sig, nil
+}
+
+func verifyDeletionToken(token string, userId string, organizationId string) error {
+	if token == "" {
+		return domain.Invalidf("confirm the deletion with the token returned by a first delete")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return domain.Invalidf("malformed confirmation token")
+	}
+	expiresAt, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return domain.Invalidf("malformed confirmation token")
+	}
+	if !secrets.Verify(deletionTokenPayload(userId, organizationId, expiresAt), parts[1]) {
+		return domain.Invalidf("invalid confirmation token")
+	}
+	if time.Now().Unix() > expiresAt {
+		return domain.Invalidf("confirmation token has expired")
+	}
+	return nil
+}
+
+func deletionTokenPayload(userId string, organizationId string, expiresAt int64) []byte {
+	return []byte(fmt.Sprintf("delete-organization:%s:%s:%d", organizationId, userId, expiresAt))
+}
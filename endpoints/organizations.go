@@ -1,111 +1,231 @@
 package endpoints
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"strings"
 	"time"
 
-	log "github.com/cihub/seelog"
 	"github.com/jinzhu/gorm"
 	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/metrics"
+	"github.com/ok-borg/api/reqlog"
 	"github.com/satori/go.uuid"
 )
 
+const (
+	maxOrganizationNameLen = 255
+	maxOrganizationMembers = 100
+	maxJoinLinkNameLen     = 255
+)
+
 func (e Endpoints) CreateOrganization(
+	ctx context.Context,
 	db *gorm.DB,
 	userId string,
 	name string,
 ) (*domain.Organization, error) {
-	organizationDao := domain.NewOrganizationDao(db)
-	// first check if organization with same name exists
-	if _, err := organizationDao.GetByName(name); err == nil {
-		// no error, we successfully get an organization,
-		// return an error
-		return nil, fmt.Errorf("An organization with the name %s already exists", name)
+	name, err := validOrganizationName(name)
+	if err != nil {
+		return nil, err
 	}
-
 	// first create organization
 	newOrganization := domain.Organization{
-		Id:   uuid.NewV4().String(),
-		Name: name,
-		// by default, the creator user is admin, in the future we may allow to change the
-		// admin user
+		Id:        uuid.NewV4().String(),
+		Name:      name,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 		CreatedBy: userId,
 		UpdatedBy: userId,
 	}
-
-	if err := organizationDao.Create(newOrganization); err != nil {
-		log.Errorf("[Endpoints.CreateOrganization] unable to create organization: %s", err.Error())
+	// then the association between organization and the creator user,
+	// an organization never exists without its owner
+	tx := db.Begin()
+	if err := createOrganizationRows(tx, newOrganization); err != nil {
+		tx.Rollback()
+		if domain.IsDuplicate(err) {
+			return nil, domain.Conflictf("An organization with the name %s already exists", name)
+		}
+		reqlog.Errorf(ctx, "[Endpoints.CreateOrganization] unable to create organization: %s", err.Error())
 		return nil, err
 	}
+	if err := tx.Commit().Error; err != nil {
+		reqlog.Errorf(ctx, "[Endpoints.CreateOrganization] unable to create organization: %s", err.Error())
+		return nil, err
+	}
+	return &newOrganization, nil
+}
 
-	// then create association between organization and the creator user
-	userOrganizationDao := domain.NewUserOrganizationDao(db)
-	newUserOrganization := domain.UserOrganization{
+func createOrganizationRows(tx *gorm.DB, o domain.Organization) error {
+	// the unique index on the name settles the concurrent creations,
+	// this check only saves an insert
+	if _, err := domain.NewOrganizationDao(tx).GetByName(o.Name); err == nil {
+		return domain.Conflictf("An organization with the name %s already exists", o.Name)
+	}
+	if err := domain.NewOrganizationDao(tx).Create(o); err != nil {
+		return err
+	}
+	return domain.NewUserOrganizationDao(tx).Create(domain.UserOrganization{
 		Id:             uuid.NewV4().String(),
-		UserId:         userId,
-		OrganizationId: newOrganization.Id,
-		IsAdmin:        1,
-		CreatedAt:      time.Now(),
-		UpdatedAt:      time.Now(),
-		CreatedBy:      userId,
-		UpdatedBy:      userId,
+		UserId:         o.CreatedBy,
+		OrganizationId: o.Id,
+		Role:           domain.RoleOwner,
+		CreatedAt:      o.CreatedAt,
+		UpdatedAt:      o.UpdatedAt,
+		CreatedBy:      o.CreatedBy,
+		UpdatedBy:      o.UpdatedBy,
+	})
+}
+
+// RenameOrganization changes the name of an organization, its snippets stay where they are
+func (e Endpoints) RenameOrganization(
+	ctx context.Context,
+	db *gorm.DB,
+	userId string,
+	organizationId string,
+	name string,
+) (*domain.Organization, error) {
+	name, err := validOrganizationName(name)
+	if err != nil {
+		return nil, err
+	}
+	userOrganization, err := domain.NewUserOrganizationDao(db).GetByUserAndOrganization(userId, organizationId)
+	if err != nil {
+		return nil, domain.Forbiddenf(
+			"user (id=%s) is not member of the organization (id=%s)",
+			userId, organizationId)
+	}
+	if !userOrganization.IsAdmin() {
+		return nil, domain.Forbiddenf(
+			"user (id=%s) is not administrator of organization (id=%s)",
+			userId, organizationId)
 	}
 
-	if err := userOrganizationDao.Create(newUserOrganization); err != nil {
-		log.Errorf("[Endpoints.CreateOrganization] unable to associate user to newly create organization: %s", err.Error())
+	organizationDao := domain.NewOrganizationDao(db)
+	if other, err := organizationDao.GetByName(name); err == nil && other.Id != organizationId {
+		return nil, domain.Conflictf("An organization with the name %s already exists", name)
+	}
+	o, err := organizationDao.GetById(organizationId)
+	if err != nil {
 		return nil, err
 	}
+	if err := requireLive(o); err != nil {
+		return nil, err
+	}
+	old := o.Name
+	o.Name = name
+	o.UpdatedAt = time.Now()
+	o.UpdatedBy = userId
+	err = withAudit(db, domain.AuditEvent{
+		OrganizationId: organizationId,
+		ActorId:        userId,
+		Action:         domain.AuditOrgRename,
+		Details:        old + " -> " + name,
+	}, func(tx *gorm.DB) error {
+		return domain.NewOrganizationDao(tx).Update(o)
+	})
+	if domain.IsDuplicate(err) {
+		return nil, domain.Conflictf("An organization with the name %s already exists", name)
+	}
+	if err != nil {
+		reqlog.Errorf(ctx, "[Endpoints.RenameOrganization] unable to rename organization %s: %s", organizationId, err.Error())
+		return nil, err
+	}
+	reqlog.Infof(ctx, "organization %s renamed from %s to %s by %s", organizationId, old, name, userId)
+	return &o, nil
+}
 
-	return &newOrganization, nil
+// validOrganizationName returns the trimmed name or why it cannot be used
+func validOrganizationName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxOrganizationNameLen {
+		return "", domain.Invalidf("organization name must be 1 to %d characters long", maxOrganizationNameLen)
+	}
+	// "me" is the owner of the personal snippets, "borg" the one of the public ones
+	if name == "me" || name == PublicBorgSnippet {
+		return "", domain.Invalidf("organization name %s is reserved", name)
+	}
+	return name, nil
 }
 
+// CreateOrganizationJoinLink adds a join link to an organization, the name,
+// ttl, max uses and role of the link are taken from link
 func (e Endpoints) CreateOrganizationJoinLink(
+	ctx context.Context,
 	db *gorm.DB,
 	userId string,
 	organizationId string,
-	ttl int64,
+	link domain.OrganizationJoinLink,
 ) (*domain.OrganizationJoinLink, error) {
 	// Get UserOrganization to check if the user is admin or not
 	userOrganizationDao := domain.NewUserOrganizationDao(db)
 	userOrganization, err := userOrganizationDao.GetByUserAndOrganization(userId, organizationId)
 	if err != nil {
-		return nil, fmt.Errorf(
+		return nil, domain.Forbiddenf(
 			"user (id=%s) is not member of the organization (id=%s)",
 			userId, organizationId)
 	}
-	if userOrganization.IsAdmin != 1 {
-		return nil, fmt.Errorf(
+	if !userOrganization.IsAdmin() {
+		return nil, domain.Forbiddenf(
 			"user (id=%s) is not administrator of organization (id=%s)",
 			userId, organizationId)
 	}
 
-	// ok so here the organization exists, the user is the admin
-	// lets check if a join-link already exist, if yes remove it then create a new one hehe.
-	organizationJoinLinkDao := domain.NewOrganizationJoinLinkDao(db)
-	if ojl, err := organizationJoinLinkDao.GetByOrganizationId(organizationId); err == nil {
-		// no error this join link exist for this organization
-		// lets remove it
-		organizationJoinLinkDao.Delete(ojl.Id)
+	link.Name = strings.TrimSpace(link.Name)
+	if len(link.Name) > maxJoinLinkNameLen {
+		return nil, domain.Invalidf("join link name must be at most %d characters long", maxJoinLinkNameLen)
+	}
+	if link.Ttl < 0 || link.MaxUses < 0 {
+		return nil, domain.Invalidf("join link ttl and max uses cannot be negative")
+	}
+	if link.Role == "" {
+		link.Role = domain.RoleEditor
+	}
+	if !domain.ValidRole(link.Role) || link.Role == domain.RoleOwner {
+		return nil, domain.Invalidf("join links cannot give the role %s", link.Role)
+	}
+	if !domain.RoleAtLeast(userOrganization.Role, link.Role) {
+		return nil, domain.Forbiddenf("a %s cannot grant the %s role", userOrganization.Role, link.Role)
+	}
+
+	o, err := domain.NewOrganizationDao(db).GetById(organizationId)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireLive(o); err != nil {
+		return nil, err
 	}
-	// now create a new one
+
+	// ok so here the organization exists, the user is the admin
 	ojl := domain.OrganizationJoinLink{
 		Id:             uuid.NewV4().String(),
 		OrganizationId: organizationId,
-		Ttl:            ttl,
+		Name:           link.Name,
+		Ttl:            link.Ttl,
+		MaxUses:        link.MaxUses,
+		Role:           link.Role,
 		CreatedAt:      time.Now(),
 		CreatedBy:      userId,
 	}
 
-	if err := organizationJoinLinkDao.Create(ojl); err != nil {
-		log.Errorf("[Endpoints.CreateOrganizationjoinlink] unable to create organiation join link for organization: %s, %s", organizationId, err.Error())
+	err = withAudit(db, domain.AuditEvent{
+		OrganizationId: organizationId,
+		ActorId:        userId,
+		Action:         domain.AuditJoinLinkCreate,
+		TargetId:       ojl.Id,
+		Details:        ojl.Name,
+	}, func(tx *gorm.DB) error {
+		return domain.NewOrganizationJoinLinkDao(tx).Create(ojl)
+	})
+	if err != nil {
+		reqlog.Errorf(ctx, "[Endpoints.CreateOrganizationjoinlink] unable to create organiation join link for organization: %s, %s", organizationId, err.Error())
 		return nil, err
 	}
 	return &ojl, nil
 }
 
+// DeleteOrganizationJoinLink revokes a join link,
+// it is kept for the members who joined with it
 func (e Endpoints) DeleteOrganizationJoinLink(
 	db *gorm.DB,
 	userId string,
@@ -114,8 +234,8 @@ func (e Endpoints) DeleteOrganizationJoinLink(
 	// get the organizastionJoinLink
 	organizationJoinLinkDao := domain.NewOrganizationJoinLinkDao(db)
 	ojl, err := organizationJoinLinkDao.GetById(organizationJoinLinkId)
-	if err != nil {
-		return fmt.Errorf("cannot fin organization join link (id=%s)",
+	if err != nil || ojl.RevokedAt != nil {
+		return domain.NotFoundf("cannot find organization join link (id=%s)",
 			organizationJoinLinkId)
 	}
 
@@ -123,30 +243,116 @@ func (e Endpoints) DeleteOrganizationJoinLink(
 	userOrganizationDao := domain.NewUserOrganizationDao(db)
 	userOrganization, err := userOrganizationDao.GetByUserAndOrganization(userId, ojl.OrganizationId)
 	if err != nil {
-		return fmt.Errorf(
+		return domain.Forbiddenf(
 			"user (id=%s) is not member of the organization (id=%s)",
 			userId, ojl.OrganizationId)
 	}
-	if userOrganization.IsAdmin != 1 {
-		return fmt.Errorf(
+	if !userOrganization.IsAdmin() {
+		return domain.Forbiddenf(
 			"user (id=%s) is not administrator of organization (id=%s)",
 			userId, ojl.OrganizationId)
 	}
 
+	o, err := domain.NewOrganizationDao(db).GetById(ojl.OrganizationId)
+	if err != nil {
+		return err
+	}
+	if err := requireLive(o); err != nil {
+		return err
+	}
+
 	// ok so here the organization exists, the user is the admin
-	// DELETE ALL THE SHIT
-	return organizationJoinLinkDao.Delete(organizationJoinLinkId)
+	now := time.Now()
+	ojl.RevokedAt = &now
+	return withAudit(db, domain.AuditEvent{
+		OrganizationId: ojl.OrganizationId,
+		ActorId:        userId,
+		Action:         domain.AuditJoinLinkRevoke,
+		TargetId:       ojl.Id,
+		Details:        ojl.Name,
+	}, func(tx *gorm.DB) error {
+		return domain.NewOrganizationJoinLinkDao(tx).Update(ojl)
+	})
 }
 
+// ListOrganizationJoinLinks returns the join links of an organization, revoked ones included
+func (e Endpoints) ListOrganizationJoinLinks(
+	db *gorm.DB,
+	userId string,
+	organizationId string,
+) ([]domain.OrganizationJoinLink, error) {
+	uo, err := requireMember(db, userId, organizationId)
+	if err != nil {
+		return nil, err
+	}
+	if !uo.IsAdmin() {
+		return nil, domain.Forbiddenf(
+			"user (id=%s) is not administrator of organization (id=%s)",
+			userId, organizationId)
+	}
+	return domain.NewOrganizationJoinLinkDao(db).ListByOrganization(organizationId)
+}
+
+// GetOrganizationJoinLink returns a join link to the admins of its organization,
+// anyone else previews it with PreviewOrganizationJoinLink
 func (e Endpoints) GetOrganizationJoinLink(
 	db *gorm.DB,
+	userId string,
 	organizationJoinLinkId string,
-) (domain.OrganizationJoinLink, error) {
+) (*domain.OrganizationJoinLink, error) {
 	organizationJoinLinkDao := domain.NewOrganizationJoinLinkDao(db)
-	return organizationJoinLinkDao.GetById(organizationJoinLinkId)
+	ojl, err := organizationJoinLinkDao.GetById(organizationJoinLinkId)
+	if err != nil {
+		return nil, domain.NotFoundf("cannot find organization join link (id=%s)",
+			organizationJoinLinkId)
+	}
+	uo, err := requireMember(db, userId, ojl.OrganizationId)
+	if err != nil {
+		return nil, err
+	}
+	if !uo.IsAdmin() {
+		return nil, domain.Forbiddenf(
+			"user (id=%s) is not administrator of organization (id=%s)",
+			userId, ojl.OrganizationId)
+	}
+	return &ojl, nil
+}
+
+// PreviewOrganizationJoinLink tells who invites to which organization, without authentication
+func (e Endpoints) PreviewOrganizationJoinLink(
+	db *gorm.DB,
+	organizationJoinLinkId string,
+) (*domain.JoinLinkPreview, error) {
+	ojl, err := domain.NewOrganizationJoinLinkDao(db).GetById(organizationJoinLinkId)
+	if err != nil || ojl.RevokedAt != nil {
+		return nil, domain.NotFoundf("cannot find organization join link (id=%s)",
+			organizationJoinLinkId)
+	}
+	o, err := domain.NewOrganizationDao(db).GetById(ojl.OrganizationId)
+	if err != nil || o.IsDeleted() {
+		return nil, domain.NotFoundf("cannot find organization join link (id=%s)",
+			organizationJoinLinkId)
+	}
+	members, _, err := domain.NewUserOrganizationDao(db).CountByOrganization(o.Id)
+	if err != nil {
+		return nil, err
+	}
+	preview := domain.JoinLinkPreview{
+		Organization: o.Name,
+		Members:      members,
+		ExpiresAt:    ojl.ExpiresAt(),
+		Role:         ojl.Role,
+		Usable:       ojl.IsUsable(),
+	}
+	// the ghost user of a deleted account has no login
+	if inviter, err := domain.NewUserDao(db).GetById(ojl.CreatedBy); err == nil {
+		preview.InvitedBy = inviter.Login
+	}
+	return &preview, nil
 }
 
 func (e Endpoints) GetOrganizationJoinLinkForOrganization(
+	ctx context.Context,
 	db *gorm.DB,
 	userId string,
 	organizationId string,
@@ -155,7 +361,7 @@ func (e Endpoints) GetOrganizationJoinLinkForOrganization(
 	organizationJoinLink, err := organizationJoinLinkDao.GetByOrganizationId(organizationId)
 
 	if err != nil {
-		log.Errorf("[Endpoints.GetOrganizationjoinlinkbyorganizationid] cannot get join link from organization: %s", err)
+		reqlog.Errorf(ctx, "[Endpoints.GetOrganizationjoinlinkbyorganizationid] cannot get join link from organization: %s", err)
 		return nil, err
 	}
 
@@ -163,12 +369,12 @@ func (e Endpoints) GetOrganizationJoinLinkForOrganization(
 	userOrganizationDao := domain.NewUserOrganizationDao(db)
 	userOrganization, err := userOrganizationDao.GetByUserAndOrganization(userId, organizationJoinLink.OrganizationId)
 	if err != nil {
-		return nil, fmt.Errorf(
+		return nil, domain.Forbiddenf(
 			"user (id=%s) is not member of the organization (id=%s)",
 			userId, organizationJoinLink.OrganizationId)
 	}
-	if userOrganization.IsAdmin != 1 {
-		return nil, fmt.Errorf(
+	if !userOrganization.IsAdmin() {
+		return nil, domain.Forbiddenf(
 			"user (id=%s) is not administrator of organization (id=%s)",
 			userId, organizationJoinLink.OrganizationId)
 	}
@@ -176,10 +382,130 @@ func (e Endpoints) GetOrganizationJoinLinkForOrganization(
 	return &organizationJoinLink, err
 }
 
-func (e Endpoints) ListUserOrganizations(db *gorm.DB, userId string) ([]domain.Organization, error) {
+// GetOrganization returns an organization with its creator and counts, to its members only
+func (e Endpoints) GetOrganization(
+	db *gorm.DB,
+	userId string,
+	organizationId string,
+) (*domain.OrganizationDetail, error) {
+	if _, err := requireMember(db, userId, organizationId); err != nil {
+		return nil, err
+	}
+	o, err := domain.NewOrganizationDao(db).GetById(organizationId)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireLive(o); err != nil {
+		return nil, err
+	}
+	d := domain.OrganizationDetail{Organization: o}
+	if d.Members, d.Admins, err = domain.NewUserOrganizationDao(db).CountByOrganization(organizationId); err != nil {
+		return nil, err
+	}
+	if creator, err := domain.NewUserDao(db).GetById(o.CreatedBy); err == nil {
+		profiles, err := userProfiles(db, userId, []domain.User{creator})
+		if err != nil {
+			return nil, err
+		}
+		d.Creator = &profiles[0]
+	}
+	start := time.Now()
+	d.Snippets, err = e.client.Count(o.Index()).IgnoreUnavailable(true).Type("problem").Do()
+	metrics.ObserveEs("count", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// ListOrganizationMembers returns a page of the members of an organization, oldest first,
+// and how many members there are. Only members can list them.
+func (e Endpoints) ListOrganizationMembers(
+	db *gorm.DB,
+	userId string,
+	organizationId string,
+	from int,
+	size int,
+) ([]domain.OrganizationMember, int64, error) {
+	if from < 0 || size <= 0 || size > maxOrganizationMembers {
+		return nil, 0, domain.Invalidf("size must be between 1 and %d, from cannot be negative", maxOrganizationMembers)
+	}
+	if _, err := requireMember(db, userId, organizationId); err != nil {
+		return nil, 0, err
+	}
+	userOrganizationDao := domain.NewUserOrganizationDao(db)
+	total, _, err := userOrganizationDao.CountByOrganization(organizationId)
+	if err != nil {
+		return nil, 0, err
+	}
+	uos, err := userOrganizationDao.ListPageByOrganization(organizationId, from, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	ids := []string{}
+	for _, uo := range uos {
+		ids = append(ids, uo.UserId)
+	}
+	users := []domain.User{}
+	if len(ids) > 0 {
+		if users, err = domain.NewUserDao(db).GetByIds(ids); err != nil {
+			return nil, 0, err
+		}
+	}
+	profiles, err := userProfiles(db, userId, users)
+	if err != nil {
+		return nil, 0, err
+	}
+	byId := map[string]domain.UserProfile{}
+	for _, p := range profiles {
+		byId[p.Id] = p
+	}
+	members := []domain.OrganizationMember{}
+	for _, uo := range uos {
+		members = append(members, domain.OrganizationMember{
+			User:       byId[uo.UserId],
+			Role:       uo.Role,
+			JoinLinkId: uo.JoinLinkId,
+			JoinedAt:   uo.CreatedAt,
+		})
+	}
+	return members, total, nil
+}
+
+// requireMember returns the membership of the user or a forbidden error
+func requireMember(db *gorm.DB, userId string, organizationId string) (domain.UserOrganization, error) {
+	uo, err := domain.NewUserOrganizationDao(db).GetByUserAndOrganization(userId, organizationId)
+	if err == gorm.ErrRecordNotFound {
+		return uo, domain.Forbiddenf(
+			"user (id=%s) is not member of the organization (id=%s)",
+			userId, organizationId)
+	}
+	return uo, err
+}
+
+// requireLive refuses to change an organization waiting for its deletion,
+// restoring it is the only way back
+func requireLive(o domain.Organization) error {
+	if o.IsDeleted() {
+		return domain.Conflictf("organization (id=%s) is deleted, restore it first", o.Id)
+	}
+	return nil
+}
+
+// lockOrganization reads the organization and keeps its members from changing
+// until the end of the transaction
+func lockOrganization(tx *gorm.DB, organizationId string) (domain.Organization, error) {
+	o, err := domain.NewOrganizationDao(tx).GetForUpdate(organizationId)
+	if err == gorm.ErrRecordNotFound {
+		return o, domain.NotFoundf("organization (id=%s) does not exist", organizationId)
+	}
+	return o, err
+}
+
+func (e Endpoints) ListUserOrganizations(ctx context.Context, db *gorm.DB, userId string) ([]domain.Organization, error) {
 	organizationIds, err := domain.NewUserOrganizationDao(db).ListOrganizationsForUser(userId)
 	if err != nil {
-		log.Errorf("[Endpoint.ListUserOrganizations]cannot list organizations for user %s", userId)
+		reqlog.Errorf(ctx, "[Endpoint.ListUserOrganizations]cannot list organizations for user %s", userId)
 		return nil, errors.New("cannot read organizations")
 	}
 	return domain.NewOrganizationDao(db).GetByIds(organizationIds)
@@ -190,36 +516,65 @@ func (e Endpoints) JoinOrganization(
 	userId string,
 	organizationJoinLinkId string,
 ) error {
-	// get the organizationJoinLink
-	organizationJoinLinkDao := domain.NewOrganizationJoinLinkDao(db)
-	ojl, err := organizationJoinLinkDao.GetById(organizationJoinLinkId)
-	if err != nil {
-		return fmt.Errorf("cannot fin organization join link (id=%s)",
+	tx := db.Begin()
+	if err := joinOrganization(tx, userId, organizationJoinLinkId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func joinOrganization(tx *gorm.DB, userId string, organizationJoinLinkId string) error {
+	// get the organizationJoinLink, locked so its uses are counted right
+	organizationJoinLinkDao := domain.NewOrganizationJoinLinkDao(tx)
+	ojl, err := organizationJoinLinkDao.GetForUpdate(organizationJoinLinkId)
+	if err != nil || ojl.RevokedAt != nil {
+		return domain.NotFoundf("cannot find organization join link (id=%s)",
 			organizationJoinLinkId)
 	}
 
 	// if not expired continue
 	if ojl.IsExpired() {
-		return errors.New("join link expired")
+		return domain.Forbiddenf("join link expired")
+	}
+	if !ojl.IsUsable() {
+		return domain.Forbiddenf("join link was used too many times")
+	}
+	if o, err := lockOrganization(tx, ojl.OrganizationId); err != nil || o.IsDeleted() {
+		return domain.NotFoundf("organization (id=%s) does not exist anymore", ojl.OrganizationId)
 	}
 
-	userOrganizationDao := domain.NewUserOrganizationDao(db)
+	userOrganizationDao := domain.NewUserOrganizationDao(tx)
 	// if already member returnn error
 	if _, err := userOrganizationDao.GetByUserAndOrganization(userId, ojl.OrganizationId); err == nil {
-		return errors.New("you already joined this organization")
+		return domain.Conflictf("you already joined this organization")
 	}
 
 	userOrganization := domain.UserOrganization{
 		Id:             uuid.NewV4().String(),
 		UserId:         userId,
 		OrganizationId: ojl.OrganizationId,
+		Role:           ojl.Role,
+		JoinLinkId:     &ojl.Id,
 		CreatedAt:      time.Now(),
 		UpdatedAt:      time.Now(),
 		CreatedBy:      userId,
 		UpdatedBy:      userId,
 	}
-
-	return userOrganizationDao.Create(userOrganization)
+	if err := userOrganizationDao.Create(userOrganization); err != nil {
+		return err
+	}
+	ojl.Uses++
+	if err := organizationJoinLinkDao.Update(ojl); err != nil {
+		return err
+	}
+	return recordAudit(tx, domain.AuditEvent{
+		OrganizationId: ojl.OrganizationId,
+		ActorId:        userId,
+		Action:         domain.AuditMemberJoin,
+		TargetId:       userId,
+		Details:        "join link " + ojl.Id + " as " + ojl.Role,
+	})
 }
 
 func (e Endpoints) LeaveOrganization(
@@ -227,28 +582,49 @@ func (e Endpoints) LeaveOrganization(
 	userId string,
 	organizationId string,
 ) error {
+	tx := db.Begin()
+	if err := leaveOrganization(tx, userId, organizationId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
 
-	userOrganizationDao := domain.NewUserOrganizationDao(db)
-	if userOrganization, err := userOrganizationDao.GetByUserAndOrganization(userId, organizationId); err != nil {
+func leaveOrganization(tx *gorm.DB, userId string, organizationId string) error {
+	// nobody joins while the owner checks they are the last one
+	o, err := lockOrganization(tx, organizationId)
+	if err != nil {
+		return err
+	}
+	if err := requireLive(o); err != nil {
+		return err
+	}
+	userOrganizationDao := domain.NewUserOrganizationDao(tx)
+	userOrganization, err := userOrganizationDao.GetByUserAndOrganization(userId, organizationId)
+	if err != nil {
 		// user is not part of this organization
-		return fmt.Errorf("[Endpoints.LeaveOrganization] User (id=%s), is not part of organization (id=%s)", userId, organizationId)
-	} else {
-		if userOrganization.IsAdmin == 1 {
-			admins, _ := userOrganizationDao.GetAdmins(organizationId)
-			// check if you are last admin, is so you can leave only if you
-			// are the last user of the org`
-			if len(admins) == 1 {
-				users, _ := userOrganizationDao.ListUsersInOrganization(organizationId)
-				if len(users) != 1 {
-					// you are not the last user, you cannot leave this shit
-					// lets transfer ownership before
-					return fmt.Errorf("[Endpoints.LeaveOrganization] User (id=%s), is the last admin for the organization, he can leave only if there is 1 member in the organization (id=%s)",
-						userId, organizationId)
-				}
-			}
+		return domain.NotFoundf("user (id=%s) is not part of organization (id=%s)", userId, organizationId)
+	}
+	if userOrganization.Role == domain.RoleOwner {
+		// the owner can leave only if nobody else is left
+		users, err := userOrganizationDao.ListUsersInOrganization(organizationId)
+		if err != nil {
+			return err
+		}
+		if len(users) != 1 {
+			return domain.Conflictf("user (id=%s) owns the organization (id=%s), they must transfer it before leaving",
+				userId, organizationId)
 		}
-		return userOrganizationDao.Delete(userOrganization.Id)
 	}
+	if err := userOrganizationDao.Delete(userOrganization.Id); err != nil {
+		return err
+	}
+	return recordAudit(tx, domain.AuditEvent{
+		OrganizationId: organizationId,
+		ActorId:        userId,
+		Action:         domain.AuditMemberLeave,
+		TargetId:       userId,
+	})
 }
 
 func (e Endpoints) ExpelUserFromOrganization(
@@ -257,16 +633,33 @@ func (e Endpoints) ExpelUserFromOrganization(
 	userIdToExpel string,
 	organizationId string,
 ) error {
+	tx := db.Begin()
+	if err := expelFromOrganization(tx, userId, userIdToExpel, organizationId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func expelFromOrganization(tx *gorm.DB, userId string, userIdToExpel string, organizationId string) error {
+	// the roles cannot change between the checks and the removal
+	o, err := lockOrganization(tx, organizationId)
+	if err != nil {
+		return err
+	}
+	if err := requireLive(o); err != nil {
+		return err
+	}
 	// first check if the user is admin
-	userOrganizationDao := domain.NewUserOrganizationDao(db)
+	userOrganizationDao := domain.NewUserOrganizationDao(tx)
 	adminOjl, err := userOrganizationDao.GetByUserAndOrganization(userId, organizationId)
 	if err != nil {
-		return fmt.Errorf(
+		return domain.Forbiddenf(
 			"user (id=%s) is not member of the organization (id=%s)",
 			userId, organizationId)
 	}
-	if adminOjl.IsAdmin != 1 {
-		return fmt.Errorf(
+	if !adminOjl.IsAdmin() {
+		return domain.Forbiddenf(
 			"user (id=%s) is not administrator of organization (id=%s)",
 			userId, organizationId)
 	}
@@ -274,37 +667,181 @@ func (e Endpoints) ExpelUserFromOrganization(
 	// then just remove the user organization assorciation
 	userOrganization, err := userOrganizationDao.GetByUserAndOrganization(userIdToExpel, organizationId)
 	if err != nil {
-		return fmt.Errorf("[Endpoints.ExpelUserFromOrganization] user (id=%s) is not part of organization (id=%s)", userIdToExpel, organizationId)
+		return domain.NotFoundf("user (id=%s) is not part of organization (id=%s)", userIdToExpel, organizationId)
 	}
-
-	return userOrganizationDao.Delete(userOrganization.Id)
+	// admins only expel the members below them
+	if !domain.RoleAbove(adminOjl.Role, userOrganization.Role) {
+		return domain.Forbiddenf("a %s cannot expel a %s", adminOjl.Role, userOrganization.Role)
+	}
+	if err := userOrganizationDao.Delete(userOrganization.Id); err != nil {
+		return err
+	}
+	return recordAudit(tx, domain.AuditEvent{
+		OrganizationId: organizationId,
+		ActorId:        userId,
+		Action:         domain.AuditMemberExpel,
+		TargetId:       userIdToExpel,
+		Details:        "was " + userOrganization.Role,
+	})
 }
 
+// GrantAdminRightToUser is ChangeMemberRole to admin, kept for the existing clients
 func (e Endpoints) GrantAdminRightToUser(
 	db *gorm.DB,
 	userId string,
 	userIdToAdmin string,
 	organizationId string,
 ) error {
-	// first check if the user is admin
-	userOrganizationDao := domain.NewUserOrganizationDao(db)
+	_, err := e.ChangeMemberRole(db, userId, userIdToAdmin, organizationId, domain.RoleAdmin)
+	return err
+}
+
+// ChangeMemberRole gives a new role to a member. Admins only change the roles
+// of the members below them and never grant more than their own role,
+// so only the owner demotes admins. The owner role is given by a transfer.
+func (e Endpoints) ChangeMemberRole(
+	db *gorm.DB,
+	userId string,
+	memberId string,
+	organizationId string,
+	role string,
+) (*domain.UserOrganization, error) {
+	if !domain.ValidRole(role) {
+		return nil, domain.Invalidf("unknown role %s", role)
+	}
+	if role == domain.RoleOwner {
+		return nil, domain.Invalidf("the organization must be transferred to change its owner")
+	}
+	tx := db.Begin()
+	userOrganization, err := changeMemberRole(tx, userId, memberId, organizationId, role)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return userOrganization, nil
+}
+
+func changeMemberRole(
+	tx *gorm.DB,
+	userId string,
+	memberId string,
+	organizationId string,
+	role string,
+) (*domain.UserOrganization, error) {
+	// the roles cannot change between the checks and the update
+	o, err := lockOrganization(tx, organizationId)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireLive(o); err != nil {
+		return nil, err
+	}
+	userOrganizationDao := domain.NewUserOrganizationDao(tx)
 	adminOjl, err := userOrganizationDao.GetByUserAndOrganization(userId, organizationId)
 	if err != nil {
-		return errors.New(fmt.Sprintf(
+		return nil, domain.Forbiddenf(
 			"user (id=%s) is not member of the organization (id=%s)",
-			userId, organizationId))
+			userId, organizationId)
 	}
-	if adminOjl.IsAdmin != 1 {
-		return errors.New(fmt.Sprintf(
+	if !adminOjl.IsAdmin() {
+		return nil, domain.Forbiddenf(
 			"user (id=%s) is not administrator of organization (id=%s)",
-			userId, organizationId))
+			userId, organizationId)
+	}
+	userOrganization, err := userOrganizationDao.GetByUserAndOrganization(memberId, organizationId)
+	if err != nil {
+		return nil, domain.NotFoundf("user (id=%s) is not part of organization (id=%s)", memberId, organizationId)
+	}
+	if !domain.RoleAbove(adminOjl.Role, userOrganization.Role) {
+		return nil, domain.Forbiddenf("a %s cannot change the role of a %s", adminOjl.Role, userOrganization.Role)
+	}
+	if !domain.RoleAtLeast(adminOjl.Role, role) {
+		return nil, domain.Forbiddenf("a %s cannot grant the %s role", adminOjl.Role, role)
+	}
+	old := userOrganization.Role
+	userOrganization.Role = role
+	userOrganization.UpdatedAt = time.Now()
+	userOrganization.UpdatedBy = userId
+	if err := userOrganizationDao.Update(userOrganization); err != nil {
+		return nil, err
 	}
+	err = recordAudit(tx, domain.AuditEvent{
+		OrganizationId: organizationId,
+		ActorId:        userId,
+		Action:         domain.AuditMemberRole,
+		TargetId:       memberId,
+		Details:        old + " -> " + role,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &userOrganization, nil
+}
 
-	// then just get  the user organization assorciation and update it
-	userOrganization, err := userOrganizationDao.GetByUserAndOrganization(userIdToAdmin, organizationId)
+// TransferOrganization makes another member the owner of the organization,
+// the previous owner stays as an admin
+func (e Endpoints) TransferOrganization(
+	ctx context.Context,
+	db *gorm.DB,
+	userId string,
+	organizationId string,
+	newOwnerId string,
+) error {
+	if newOwnerId == userId {
+		return domain.Invalidf("user (id=%s) already owns the organization (id=%s)", userId, organizationId)
+	}
+	tx := db.Begin()
+	if err := transferOrganizationRows(tx, userId, organizationId, newOwnerId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	reqlog.Infof(ctx, "organization %s transferred from %s to %s", organizationId, userId, newOwnerId)
+	return nil
+}
+
+func transferOrganizationRows(tx *gorm.DB, userId string, organizationId string, newOwnerId string) error {
+	o, err := lockOrganization(tx, organizationId)
 	if err != nil {
-		return fmt.Errorf("[Endpoints.ExpelUserFromOrganization] user (id=%s) is not part of organization (id=%s)", userIdToAdmin, organizationId)
+		return err
 	}
-	userOrganization.IsAdmin = 1
-	return userOrganizationDao.Update(userOrganization)
+	if err := requireLive(o); err != nil {
+		return err
+	}
+	userOrganizationDao := domain.NewUserOrganizationDao(tx)
+	owner, err := requireMember(tx, userId, organizationId)
+	if err != nil {
+		return err
+	}
+	if owner.Role != domain.RoleOwner {
+		return domain.Forbiddenf("user (id=%s) is not the owner of organization (id=%s)", userId, organizationId)
+	}
+	heir, err := userOrganizationDao.GetByUserAndOrganization(newOwnerId, organizationId)
+	if err != nil {
+		return domain.NotFoundf("user (id=%s) is not part of organization (id=%s)", newOwnerId, organizationId)
+	}
+	now := time.Now()
+	owner.Role = domain.RoleAdmin
+	owner.UpdatedAt = now
+	owner.UpdatedBy = userId
+	if err := userOrganizationDao.Update(owner); err != nil {
+		return err
+	}
+	heir.Role = domain.RoleOwner
+	heir.UpdatedAt = now
+	heir.UpdatedBy = userId
+	if err := userOrganizationDao.Update(heir); err != nil {
+		return err
+	}
+	return recordAudit(tx, domain.AuditEvent{
+		OrganizationId: organizationId,
+		ActorId:        userId,
+		Action:         domain.AuditOrgTransfer,
+		TargetId:       newOwnerId,
+	})
 }
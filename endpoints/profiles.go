@@ -0,0 +1,99 @@
+package endpoints
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ok-borg/api/ctxext"
+	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/identity"
+	"github.com/ok-borg/api/reqlog"
+)
+
+// users who used a token in this period get their profile synced
+const profileSyncActiveFor = 30 * 24 * time.Hour
+
+// syncProfile copies the profile of the identity into the user.
+// Only the provider the account was created with owns the profile,
+// other linked identities never overwrite it.
+func syncProfile(db *gorm.DB, user domain.User, id identity.Identity) (domain.User, error) {
+	if accountTypes[id.Provider] != user.AccountType {
+		return user, nil
+	}
+	if user.Login == id.Login && user.Name == id.Name &&
+		user.Email == id.Email && user.AvatarUrl == id.AvatarUrl {
+		return user, nil
+	}
+	userDao := domain.NewUserDao(db)
+	if user.Login != id.Login {
+		if err := userDao.ReleaseLogin(id.Login, user.AccountType, user.Id); err != nil {
+			return user, err
+		}
+	}
+	user.Login = id.Login
+	user.Name = id.Name
+	user.Email = id.Email
+	user.AvatarUrl = id.AvatarUrl
+	user.UpdatedAt = time.Now()
+	return user, userDao.Update(user)
+}
+
+// SyncProfiles refreshes the profile of every active user from its provider
+func (e *Endpoints) SyncProfiles(ctx context.Context) {
+	start := time.Now()
+	ids, err := domain.NewAccessTokenDao(e.db).ListActiveUserIds(start.Add(-profileSyncActiveFor))
+	if err != nil {
+		reqlog.Errorf(ctx, "[Endpoints.SyncProfiles] unable to list active users: %s", err.Error())
+		return
+	}
+	synced := 0
+	for _, userId := range ids {
+		if err := e.syncUserProfile(ctx, userId); err != nil {
+			reqlog.Warnf(ctx, "[Endpoints.SyncProfiles] unable to sync user %s: %s", userId, err.Error())
+			continue
+		}
+		synced++
+	}
+	reqlog.Infof(ctx, "%d/%d profiles synced in %v", synced, len(ids), time.Since(start))
+}
+
+// SyncProfilesEvery runs SyncProfiles forever
+func (e *Endpoints) SyncProfilesEvery(interval time.Duration) {
+	ctx := ctxext.WithRequestId(context.Background(), "profile-sync")
+	for range time.Tick(interval) {
+		e.SyncProfiles(ctx)
+	}
+}
+
+func (e *Endpoints) syncUserProfile(ctx context.Context, userId string) error {
+	user, err := domain.NewUserDao(e.db).GetById(userId)
+	if err != nil {
+		return err
+	}
+	links, err := domain.NewIdentityLinkDao(e.db).ListByBorgUser(userId)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if accountTypes[link.Provider] != user.AccountType {
+			continue
+		}
+		p, ok := e.providers[link.Provider]
+		if !ok {
+			// the provider is not configured anymore
+			return nil
+		}
+		tkn, err := linkToken(ctx, e.db, p, link)
+		if err != nil {
+			return err
+		}
+		id, err := p.Profile(ctx, tkn)
+		if err != nil {
+			return err
+		}
+		_, err = syncProfile(e.db, user, id)
+		return err
+	}
+	return nil
+}
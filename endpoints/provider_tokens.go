@@ -0,0 +1,69 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/identity"
+	"github.com/ok-borg/api/secrets"
+	"golang.org/x/oauth2"
+)
+
+// setLinkToken keeps the provider token in the link, never in clear,
+// with what is needed to refresh it once it expired
+func setLinkToken(link *domain.IdentityLink, tkn *oauth2.Token) error {
+	encrypted, err := secrets.Encrypt(tkn.AccessToken)
+	if err != nil {
+		return fmt.Errorf("error encrypting %s token: %s", link.Provider, err.Error())
+	}
+	link.EncryptedToken = encrypted
+	link.EncryptedRefreshToken = ""
+	if tkn.RefreshToken != "" {
+		if link.EncryptedRefreshToken, err = secrets.Encrypt(tkn.RefreshToken); err != nil {
+			return fmt.Errorf("error encrypting %s refresh token: %s", link.Provider, err.Error())
+		}
+	}
+	link.TokenExpiresAt = nil
+	if !tkn.Expiry.IsZero() {
+		expiresAt := tkn.Expiry
+		link.TokenExpiresAt = &expiresAt
+	}
+	return nil
+}
+
+// linkToken returns the provider token of a link, refreshed by the provider when it expired.
+// A refreshed token is saved, providers may rotate the refresh token with it.
+func linkToken(ctx context.Context, db *gorm.DB, p identity.Provider, link domain.IdentityLink) (*oauth2.Token, error) {
+	raw, err := secrets.Decrypt(link.EncryptedToken)
+	if err != nil {
+		return nil, err
+	}
+	tkn := &oauth2.Token{AccessToken: raw}
+	if link.EncryptedRefreshToken != "" {
+		if tkn.RefreshToken, err = secrets.Decrypt(link.EncryptedRefreshToken); err != nil {
+			return nil, err
+		}
+	}
+	if link.TokenExpiresAt != nil {
+		tkn.Expiry = *link.TokenExpiresAt
+	}
+	fresh, err := p.Refresh(ctx, tkn)
+	if err != nil {
+		return nil, fmt.Errorf("error refreshing %s token: %s", link.Provider, err.Error())
+	}
+	if fresh.AccessToken == tkn.AccessToken {
+		return fresh, nil
+	}
+	// a refresh response may leave the refresh token out to keep the current one
+	if fresh.RefreshToken == "" {
+		fresh.RefreshToken = tkn.RefreshToken
+	}
+	if err := setLinkToken(&link, fresh); err != nil {
+		return nil, err
+	}
+	link.UpdatedAt = time.Now()
+	return fresh, domain.NewIdentityLinkDao(db).Update(link)
+}
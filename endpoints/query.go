@@ -1,15 +1,19 @@
 package endpoints
 
 import (
-	log "github.com/cihub/seelog"
+	"context"
+	"reflect"
+	"time"
+
 	"github.com/jpillora/go-ogle-analytics"
+	"github.com/ok-borg/api/metrics"
+	"github.com/ok-borg/api/reqlog"
 	"github.com/ok-borg/borg/types"
 	"gopkg.in/olivere/elastic.v3"
-	"reflect"
 )
 
 // Query the borg
-func (e *Endpoints) Query(q string, size int, private bool) ([]types.Problem, error) {
+func (e *Endpoints) Query(ctx context.Context, q string, size int, private bool) ([]types.Problem, error) {
 	if size > 50 {
 		size = 50
 	}
@@ -17,15 +21,17 @@ func (e *Endpoints) Query(q string, size int, private bool) ([]types.Problem, er
 	if private {
 		ql = "PRIVATE"
 	}
-	log.Infof("Querying %v with size '%v'", ql, size)
+	reqlog.Infof(ctx, "Querying %v with size '%v'", ql, size)
 	if e.analytics != nil {
 		err := e.analytics.Send(ga.NewEvent("search", "backend").Label(ql))
 		if err != nil {
-			log.Warnf("Failed to send analytics events: %v", err)
+			reqlog.Warnf(ctx, "Failed to send analytics events: %v", err)
 		}
 	}
+	start := time.Now()
 	res, err := e.client.Search().Index("borg").Type("problem").From(0).Size(size).Query(
 		elastic.NewMultiMatchQuery(q).FieldWithBoost("Title", 5.0).Field("Solutions.Body")).Do()
+	metrics.ObserveEs("search", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -36,5 +42,6 @@ func (e *Endpoints) Query(q string, size int, private bool) ([]types.Problem, er
 			all = append(all, t)
 		}
 	}
+	metrics.ObserveSearch(start, len(all))
 	return all, nil
 }
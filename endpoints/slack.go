@@ -2,12 +2,13 @@ package endpoints
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
 
-	log "github.com/cihub/seelog"
 	"github.com/crufter/slugify"
+	"github.com/ok-borg/api/reqlog"
 )
 
 //TODO: Should I leave the structs here or move them to types package ?
@@ -26,10 +27,10 @@ type SlackAttachments struct {
 }
 
 // TODO: Return a specific message if there is no results
-func (e Endpoints) Slack(text string) (string, error) {
-	problems, err := e.Query(text, 3, false)
+func (e Endpoints) Slack(ctx context.Context, text string) (string, error) {
+	problems, err := e.Query(ctx, text, 3, false)
 	if err != nil {
-		log.Errorf("[endpoint.Slack] error processing slack command: %s ", err.Error())
+		reqlog.Errorf(ctx, "[endpoint.Slack] error processing slack command: %s ", err.Error())
 		return "", err
 	}
 
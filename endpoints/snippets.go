@@ -1,14 +1,20 @@
 package endpoints
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
+	"io"
 	"reflect"
 	"time"
 
-	log "github.com/cihub/seelog"
+	"github.com/jinzhu/gorm"
+	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/metrics"
+	"github.com/ok-borg/api/reqlog"
 	"github.com/ok-borg/api/types"
 	"github.com/ventu-io/go-shortid"
+	"gopkg.in/olivere/elastic.v3"
 )
 
 const (
@@ -17,11 +23,13 @@ const (
 
 // GetSnippet by id
 func (e Endpoints) GetSnippet(index string, id string) (*types.Problem, error) {
+	start := time.Now()
 	res, err := e.client.Get().
 		Index(index).
 		Type("problem").
 		Id(id).
 		Do()
+	metrics.ObserveEs("get", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -35,6 +43,7 @@ func (e Endpoints) GetSnippet(index string, id string) (*types.Problem, error) {
 
 // GetLatestSnippets in reverse chronological order
 func (e *Endpoints) GetLatestSnippets(index string) ([]types.Problem, error) {
+	start := time.Now()
 	res, err := e.client.Search().
 		Index(index).
 		Type("problem").
@@ -42,6 +51,7 @@ func (e *Endpoints) GetLatestSnippets(index string) ([]types.Problem, error) {
 		Size(50).
 		Sort("Created", false).
 		Do()
+	metrics.ObserveEs("latest", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -56,14 +66,15 @@ func (e *Endpoints) GetLatestSnippets(index string) ([]types.Problem, error) {
 }
 
 // CreateSnippet saves a snippet, generates id
-func (e Endpoints) CreateSnippet(snipp *types.Problem, index string, userId string) error {
+func (e Endpoints) CreateSnippet(ctx context.Context, snipp *types.Problem, index string, userId string) error {
 	if snipp.Title == "" || len(snipp.Solutions) == 0 {
-		return errors.New("Title or solutions missing")
+		return domain.Invalidf("Title or solutions missing")
 	}
 	snipp.Id = shortid.MustGenerate()
 	snipp.CreatedBy = userId
 	snipp.Created = time.Now()
-	log.Infof("Snippet with id %v is created by %v", snipp.Id, snipp.CreatedBy)
+	reqlog.Infof(ctx, "Snippet with id %v is created by %v", snipp.Id, snipp.CreatedBy)
+	start := time.Now()
 	_, err := e.client.Index().
 		Index(index).
 		Type("problem").
@@ -71,34 +82,221 @@ func (e Endpoints) CreateSnippet(snipp *types.Problem, index string, userId stri
 		BodyJson(snipp).
 		Refresh(true).
 		Do()
-	return err
+	metrics.ObserveEs("create", start, err)
+	if err != nil {
+		return err
+	}
+	e.auditSnippet(ctx, index, userId, domain.AuditSnippetCreate, snipp.Id, snipp.Title)
+	return nil
 }
 
-// UpdateSnippet saves a snippet
-func (e Endpoints) UpdateSnippet(snipp *types.Problem, index string, userId string) error {
+// UpdateSnippet saves the title and the solutions of a snippet
+func (e Endpoints) UpdateSnippet(ctx context.Context, snipp *types.Problem, index string, userId string) error {
 	if snipp.Id == "" {
-		return errors.New("No id found")
+		return domain.Invalidf("No id found")
 	}
 	if snipp.Title == "" || len(snipp.Solutions) == 0 {
-		return errors.New("Title or solutions missing")
+		return domain.Invalidf("Title or solutions missing")
 	}
 	snipp.LastUpdatedBy = userId
 	snipp.LastUpdated = time.Now()
-	log.Infof("Snippet %v is being updated by %v", snipp.Id, snipp.LastUpdatedBy)
-	_, err := e.client.Index().
+	reqlog.Infof(ctx, "Snippet %v is being updated by %v", snipp.Id, snipp.LastUpdatedBy)
+	// only the edited fields are sent, the creator and the worked votes are the server's
+	start := time.Now()
+	_, err := e.client.Update().
 		Index(index).
 		Type("problem").
 		Id(snipp.Id).
-		BodyJson(snipp).
+		Doc(map[string]interface{}{
+			"Title":         snipp.Title,
+			"Solutions":     snipp.Solutions,
+			"LastUpdatedBy": snipp.LastUpdatedBy,
+			"Updated":       snipp.LastUpdated,
+		}).
 		Refresh(true).
 		Do()
+	metrics.ObserveEs("update", start, err)
+	if elastic.IsNotFound(err) {
+		return domain.NotFoundf("snippet %s not found", snipp.Id)
+	}
 	if err != nil {
-		log.Errorf("[updateSnippet] error updating snippet id: %s: %v", snipp.Id, err)
+		reqlog.Errorf(ctx, "[updateSnippet] error updating snippet id: %s: %v", snipp.Id, err)
 		return err
 	}
+	e.auditSnippet(ctx, index, userId, domain.AuditSnippetUpdate, snipp.Id, snipp.Title)
 	return nil
 }
 
-func deleteSnippet() {
+// WithAuthors resolves the author of every snippet,
+// the snippets imported from elsewhere have none
+func (e Endpoints) WithAuthors(db *gorm.DB, problems []types.Problem) ([]types.Snippet, error) {
+	ids := []string{}
+	for _, p := range problems {
+		if p.CreatedBy != "" {
+			ids = append(ids, p.CreatedBy)
+		}
+	}
+	authors := map[string]*types.Author{}
+	if len(ids) > 0 {
+		users, err := domain.NewUserDao(db).GetByIds(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			authors[u.Id] = &types.Author{Id: u.Id, Login: u.Login, AvatarUrl: u.AvatarUrl}
+		}
+	}
+	snippets := []types.Snippet{}
+	for _, p := range problems {
+		snippets = append(snippets, types.Snippet{Problem: p, Author: authors[p.CreatedBy]})
+	}
+	return snippets, nil
+}
+
+// reassignSnippets gives the snippets of fromUserId to toUserId in every index,
+// the personal index of fromUserId is moved to the one of toUserId.
+// Running it again after a failure finishes the job.
+func (e Endpoints) reassignSnippets(ctx context.Context, fromUserId string, toUserId string) error {
+	start := time.Now()
+	err := e.doReassignSnippets(ctx, fromUserId, toUserId)
+	metrics.ObserveEs("reassign", start, err)
+	return err
+}
+
+func (e Endpoints) doReassignSnippets(ctx context.Context, fromUserId string, toUserId string) error {
+	indexes, err := e.snippetIndexes(fromUserId)
+	if err != nil {
+		return err
+	}
+	q := elastic.NewBoolQuery().
+		Should(elastic.NewMatchPhraseQuery("CreatedBy", fromUserId)).
+		Should(elastic.NewMatchPhraseQuery("LastUpdatedBy", fromUserId))
+	scroll := e.client.Scroll(indexes...).Type("problem").Query(q).Size(100)
+	moved := 0
+	for {
+		res, err := scroll.Do()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		bulk := e.client.Bulk().Refresh(true)
+		for _, hit := range res.Hits.Hits {
+			// the raw source is kept, it holds more than types.Problem, the votes for one
+			source := map[string]interface{}{}
+			if err := json.Unmarshal(*hit.Source, &source); err != nil {
+				return err
+			}
+			if source["CreatedBy"] == fromUserId {
+				source["CreatedBy"] = toUserId
+			}
+			if source["LastUpdatedBy"] == fromUserId {
+				source["LastUpdatedBy"] = toUserId
+			}
+			index := hit.Index
+			if index == fromUserId {
+				index = toUserId
+				bulk.Add(elastic.NewBulkDeleteRequest().Index(hit.Index).Type("problem").Id(hit.Id))
+			}
+			bulk.Add(elastic.NewBulkIndexRequest().Index(index).Type("problem").Id(hit.Id).Doc(source))
+			moved++
+		}
+		if bulk.NumberOfActions() == 0 {
+			continue
+		}
+		bres, err := bulk.Do()
+		if err != nil {
+			return err
+		}
+		if failed := bres.Failed(); len(failed) > 0 {
+			return fmt.Errorf("unable to reassign snippet %s: status %d", failed[0].Id, failed[0].Status)
+		}
+	}
+	reqlog.Infof(ctx, "%d snippets reassigned from %s to %s", moved, fromUserId, toUserId)
+
+	// the personal index is empty now
+	return e.dropIndex(fromUserId)
+}
+
+// snippetIndexes returns the indexes a user may have written snippets to:
+// the public one, their personal one and the ones of the organizations.
+// The missing ones are left out, elastic search refuses to scroll them.
+func (e Endpoints) snippetIndexes(userId string) ([]string, error) {
+	indexes := []string{domain.OrganizationIndexes}
+	for _, index := range []string{PublicBorgSnippet, userId} {
+		exists, err := e.client.IndexExists(index).Do()
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes, nil
+}
+
+// dropIndex deletes an index and its snippets, a missing index is not an error
+func (e Endpoints) dropIndex(index string) error {
+	start := time.Now()
+	exists, err := e.client.IndexExists(index).Do()
+	if err == nil && exists {
+		_, err = e.client.DeleteIndex(index).Do()
+	}
+	metrics.ObserveEs("drop_index", start, err)
+	return err
+}
 
+// allSnippets returns every snippet of an index
+func (e Endpoints) allSnippets(index string) ([]types.Problem, error) {
+	all := []types.Problem{}
+	exists, err := e.client.IndexExists(index).Do()
+	if err != nil || !exists {
+		return all, err
+	}
+	scroll := e.client.Scroll(index).Type("problem").Size(100)
+	for {
+		res, err := scroll.Do()
+		if err == io.EOF {
+			return all, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var ttyp types.Problem
+		for _, item := range res.Each(reflect.TypeOf(ttyp)) {
+			if t, ok := item.(types.Problem); ok {
+				all = append(all, t)
+			}
+		}
+	}
+}
+
+// DeleteSnippet removes a snippet from its index,
+// in the public index only its creator can delete it
+func (e Endpoints) DeleteSnippet(ctx context.Context, index string, id string, userId string) error {
+	snipp, err := e.GetSnippet(index, id)
+	if err != nil {
+		return err
+	}
+	if snipp == nil {
+		return domain.NotFoundf("snippet %s not found", id)
+	}
+	if index == PublicBorgSnippet && snipp.CreatedBy != userId {
+		return domain.Forbiddenf("only the creator of a public snippet can delete it")
+	}
+	reqlog.Infof(ctx, "Snippet %v is deleted by %v", id, userId)
+	start := time.Now()
+	_, err = e.client.Delete().
+		Index(index).
+		Type("problem").
+		Id(id).
+		Refresh(true).
+		Do()
+	metrics.ObserveEs("delete", start, err)
+	if err != nil {
+		return err
+	}
+	e.auditSnippet(ctx, index, userId, domain.AuditSnippetDelete, id, snipp.Title)
+	return nil
 }
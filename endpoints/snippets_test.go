@@ -0,0 +1,153 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/types"
+	"gopkg.in/olivere/elastic.v3"
+)
+
+// fakeEs stores the documents indexed, updated and deleted by id,
+// which is all the snippet endpoints need from elastic search
+type fakeEs struct {
+	mtx  sync.Mutex
+	docs map[string]map[string]interface{}
+}
+
+func newFakeEs(t *testing.T) (*fakeEs, *elastic.Client, func()) {
+	f := &fakeEs{docs: map[string]map[string]interface{}{}}
+	srv := httptest.NewServer(f)
+	client, err := elastic.NewClient(elastic.SetURL(srv.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return f, client, srv.Close
+}
+
+func (f *fakeEs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 3 {
+		http.Error(w, `{"error":"unsupported"}`, http.StatusBadRequest)
+		return
+	}
+	index, typ, id := parts[0], parts[1], parts[2]
+	key := index + "/" + typ + "/" + id
+	w.Header().Set("Content-Type", "application/json")
+	meta := map[string]interface{}{"_index": index, "_type": typ, "_id": id, "_version": 1}
+	doc, found := f.docs[key]
+	switch {
+	case r.Method == "GET":
+		meta["found"] = found
+		if found {
+			meta["_source"] = doc
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == "POST" && len(parts) == 4 && parts[3] == "_update":
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "document_missing_exception", "status": 404})
+			return
+		}
+		body := struct {
+			Doc map[string]interface{} `json:"doc"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		for k, v := range body.Doc {
+			doc[k] = v
+		}
+	case r.Method == "PUT" || r.Method == "POST":
+		doc = map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&doc)
+		f.docs[key] = doc
+		meta["created"] = !found
+	case r.Method == "DELETE":
+		meta["found"] = found
+		delete(f.docs, key)
+	}
+	json.NewEncoder(w).Encode(meta)
+}
+
+func (f *fakeEs) doc(index string, id string) map[string]interface{} {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.docs[index+"/problem/"+id]
+}
+
+func TestUpdateSnippetKeepsServerFields(t *testing.T) {
+	es, client, stop := newFakeEs(t)
+	defer stop()
+	e := Endpoints{client: client}
+	ctx := context.Background()
+	snipp := &types.Problem{Title: "a", Solutions: []types.Solution{{Body: []string{"b"}}}}
+	if err := e.CreateSnippet(ctx, snipp, PublicBorgSnippet, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	update := &types.Problem{
+		Id:        snipp.Id,
+		Title:     "a better title",
+		Solutions: snipp.Solutions,
+		CreatedBy: "mallory",
+	}
+	if err := e.UpdateSnippet(ctx, update, PublicBorgSnippet, "mallory"); err != nil {
+		t.Fatal(err)
+	}
+	doc := es.doc(PublicBorgSnippet, snipp.Id)
+	if doc["Title"] != "a better title" || doc["LastUpdatedBy"] != "mallory" {
+		t.Fatalf("snippet not updated: %v", doc)
+	}
+	if doc["CreatedBy"] != "alice" {
+		t.Fatalf("the creator was changed to %v", doc["CreatedBy"])
+	}
+
+	err := e.DeleteSnippet(ctx, PublicBorgSnippet, snipp.Id, "mallory")
+	if domain.Kind(err) != domain.ErrForbidden {
+		t.Fatalf("a non creator deleted the snippet after updating it, error: %v", err)
+	}
+	if err := e.DeleteSnippet(ctx, PublicBorgSnippet, snipp.Id, "alice"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateMissingSnippet(t *testing.T) {
+	_, client, stop := newFakeEs(t)
+	defer stop()
+	e := Endpoints{client: client}
+	update := &types.Problem{Id: "missing", Title: "a", Solutions: []types.Solution{{Body: []string{"b"}}}}
+	err := e.UpdateSnippet(context.Background(), update, PublicBorgSnippet, "alice")
+	if domain.Kind(err) != domain.ErrNotFound {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+}
+
+func TestUpdateSnippetKeepsWorkedVotes(t *testing.T) {
+	es, client, stop := newFakeEs(t)
+	defer stop()
+	e := Endpoints{client: client}
+	ctx := context.Background()
+	snipp := &types.Problem{Title: "a", Solutions: []types.Solution{{Body: []string{"b"}}}}
+	if err := e.CreateSnippet(ctx, snipp, PublicBorgSnippet, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	es.doc(PublicBorgSnippet, snipp.Id)["worked"] = []interface{}{"some query"}
+
+	update := &types.Problem{Id: snipp.Id, Title: "a", Solutions: []types.Solution{{Body: []string{"c"}}}}
+	if err := e.UpdateSnippet(ctx, update, PublicBorgSnippet, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	worked, _ := es.doc(PublicBorgSnippet, snipp.Id)["worked"].([]interface{})
+	if len(worked) != 1 {
+		t.Fatalf("the worked votes were lost: %v", es.doc(PublicBorgSnippet, snipp.Id))
+	}
+}
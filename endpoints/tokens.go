@@ -0,0 +1,131 @@
+package endpoints
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/reqlog"
+)
+
+// CreatePersonalAccessToken issues a long lived token for the cli or automation.
+// The scopes and the lifetime cannot exceed the ones of the token used to create it,
+// a ttl of 0 days means the token lives as long as this one.
+func (e Endpoints) CreatePersonalAccessToken(
+	ctx context.Context,
+	db *gorm.DB,
+	current domain.AccessToken,
+	name string,
+	scopes domain.Scopes,
+	ttlDays int,
+) (*domain.AccessToken, error) {
+	if name == "" {
+		return nil, domain.Invalidf("a name is required")
+	}
+	if ttlDays < 0 {
+		return nil, domain.Invalidf("ttl cannot be negative")
+	}
+	if err := scopes.Validate(); err != nil {
+		return nil, err
+	}
+	for _, scope := range scopes {
+		if !current.Scopes.Has(scope) {
+			return nil, domain.Forbiddenf("current token lacks the %s scope", scope)
+		}
+	}
+
+	token := domain.AccessToken{
+		Name:       name,
+		Scopes:     scopes,
+		IsPersonal: 1,
+		UserId:     current.UserId,
+	}
+	if ttlDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, ttlDays)
+		token.ExpiresAt = &expiresAt
+	}
+	// a token never outlives the one that created it
+	if current.ExpiresAt != nil && (token.ExpiresAt == nil || token.ExpiresAt.After(*current.ExpiresAt)) {
+		expiresAt := *current.ExpiresAt
+		token.ExpiresAt = &expiresAt
+	}
+	t, err := issueAccessToken(db, token)
+	if err != nil {
+		reqlog.Errorf(ctx, "[Endpoints.CreatePersonalAccessToken] %s", err.Error())
+		return nil, err
+	}
+	reqlog.Infof(ctx, "Personal access token %s created by %s", t.Id, t.UserId)
+	return t, nil
+}
+
+func (e Endpoints) ListPersonalAccessTokens(
+	db *gorm.DB,
+	userId string,
+) ([]domain.AccessToken, error) {
+	return domain.NewAccessTokenDao(db).ListPersonalByUser(userId)
+}
+
+func (e Endpoints) RenamePersonalAccessToken(
+	db *gorm.DB,
+	userId string,
+	tokenId string,
+	name string,
+) (*domain.AccessToken, error) {
+	if name == "" {
+		return nil, domain.Invalidf("a name is required")
+	}
+	token, err := getPersonalAccessToken(db, userId, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	token.Name = name
+	token.UpdatedAt = time.Now()
+	if err := domain.NewAccessTokenDao(db).Update(token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RevokeAccessToken revokes one of the tokens of the user, personal or issued at login
+func (e Endpoints) RevokeAccessToken(
+	ctx context.Context,
+	db *gorm.DB,
+	userId string,
+	tokenId string,
+) error {
+	token, err := domain.NewAccessTokenDao(db).GetById(tokenId)
+	if err != nil || token.UserId != userId {
+		return domain.NotFoundf("cannot find access token (id=%s)", tokenId)
+	}
+	reqlog.Infof(ctx, "Access token %s revoked by %s", token.Id, userId)
+	return domain.NewAccessTokenDao(db).Delete(token.Id)
+}
+
+// Logout revokes the token used for the request
+func (e Endpoints) Logout(
+	ctx context.Context,
+	db *gorm.DB,
+	token string,
+) error {
+	return domain.NewAccessTokenDao(db).DeleteByToken(token)
+}
+
+// LogoutEverywhere revokes every token of the user, personal tokens included
+func (e Endpoints) LogoutEverywhere(
+	ctx context.Context,
+	db *gorm.DB,
+	userId string,
+) error {
+	reqlog.Infof(ctx, "All access tokens of %s revoked", userId)
+	return domain.NewAccessTokenDao(db).DeleteByUser(userId)
+}
+
+// tokens of other users are reported as not found to not leak their ids
+func getPersonalAccessToken(db *gorm.DB, userId string, tokenId string) (domain.AccessToken, error) {
+	token, err := domain.NewAccessTokenDao(db).GetById(tokenId)
+	if err != nil || token.UserId != userId || token.IsPersonal != 1 {
+		return domain.AccessToken{}, domain.NotFoundf("cannot find personal access token (id=%s)", tokenId)
+	}
+	return token, nil
+}
@@ -0,0 +1,235 @@
+package endpoints
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/identity"
+	"github.com/ok-borg/api/metrics"
+	"github.com/ok-borg/api/types"
+	"gopkg.in/olivere/elastic.v3"
+)
+
+const (
+	minUserSearchLen     = 2
+	maxUserSearchResults = 20
+	maxUserSnippets      = 50
+)
+
+// GetUserProfile returns the public profile of a user with its contributions
+// the viewer can see, viewerId may be empty
+func (e Endpoints) GetUserProfile(db *gorm.DB, viewerId string, login string) (*domain.UserProfile, error) {
+	u, err := getUserByLogin(db, login)
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := userProfiles(db, viewerId, []domain.User{u})
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := visibleIndexes(db, viewerId, u.Id)
+	if err != nil {
+		return nil, err
+	}
+	if profiles[0].Stats, err = e.userStats(indexes, u.Id); err != nil {
+		return nil, err
+	}
+	return &profiles[0], nil
+}
+
+// GetUserSnippets returns the snippets created by a user, most recent first:
+// the public ones and the ones of the organizations shared with the viewer
+func (e Endpoints) GetUserSnippets(
+	db *gorm.DB,
+	viewerId string,
+	login string,
+	from int,
+	size int,
+) ([]types.Snippet, error) {
+	if from < 0 || size <= 0 || size > maxUserSnippets {
+		return nil, domain.Invalidf("size must be between 1 and %d, from cannot be negative", maxUserSnippets)
+	}
+	u, err := getUserByLogin(db, login)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := visibleIndexes(db, viewerId, u.Id)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	res, err := e.client.Search().
+		Index(indexes...).
+		IgnoreUnavailable(true).
+		Type("problem").
+		Query(elastic.NewMatchPhraseQuery("CreatedBy", u.Id)).
+		Sort("Created", false).
+		From(from).
+		Size(size).
+		Do()
+	metrics.ObserveEs("user_snippets", start, err)
+	if err != nil {
+		return nil, err
+	}
+	all := []types.Problem{}
+	var ttyp types.Problem
+	for _, item := range res.Each(reflect.TypeOf(ttyp)) {
+		if t, ok := item.(types.Problem); ok {
+			all = append(all, t)
+		}
+	}
+	return e.WithAuthors(db, all)
+}
+
+// userStats counts the contributions of a user in the given indexes
+func (e Endpoints) userStats(indexes []string, userId string) (*domain.UserStats, error) {
+	stats := domain.UserStats{}
+	start := time.Now()
+	edits, err := e.client.Count(indexes...).
+		IgnoreUnavailable(true).
+		Type("problem").
+		Query(elastic.NewMatchPhraseQuery("LastUpdatedBy", userId)).
+		Do()
+	metrics.ObserveEs("user_stats", start, err)
+	if err != nil {
+		return nil, err
+	}
+	stats.Edits = edits
+
+	// the worked votes are the queries stored in each created snippet,
+	// elastic search adds them up so no snippet leaves the cluster.
+	// CreatedBy and the votes are never taken from a client, updates leave them be.
+	start = time.Now()
+	res, err := e.client.Search().
+		Index(indexes...).
+		IgnoreUnavailable(true).
+		Type("problem").
+		Query(elastic.NewMatchPhraseQuery("CreatedBy", userId)).
+		Aggregation("worked", elastic.NewSumAggregation().Script(elastic.NewScriptInline(workedCountScript))).
+		Size(0).
+		Do()
+	metrics.ObserveEs("user_stats", start, err)
+	if err != nil {
+		return nil, err
+	}
+	stats.Snippets = res.TotalHits()
+	if worked, ok := res.Aggregations.Sum("worked"); ok && worked.Value != nil {
+		stats.Worked = int64(*worked.Value)
+	}
+	return &stats, nil
+}
+
+// visibleIndexes returns the indexes holding the snippets of authorId viewerId may see:
+// the public one and the ones of the organizations they share
+func visibleIndexes(db *gorm.DB, viewerId string, authorId string) ([]string, error) {
+	indexes := []string{PublicBorgSnippet}
+	if viewerId == "" {
+		return indexes, nil
+	}
+	userOrganizationDao := domain.NewUserOrganizationDao(db)
+	viewerOrgs, err := userOrganizationDao.ListOrganizationsForUser(viewerId)
+	if err != nil {
+		return nil, err
+	}
+	authorOrgs, err := userOrganizationDao.ListOrganizationsForUser(authorId)
+	if err != nil {
+		return nil, err
+	}
+	shared := []string{}
+	for _, a := range authorOrgs {
+		for _, v := range viewerOrgs {
+			if a == v {
+				shared = append(shared, a)
+			}
+		}
+	}
+	if len(shared) == 0 {
+		return indexes, nil
+	}
+	orgs, err := domain.NewOrganizationDao(db).GetByIds(shared)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range orgs {
+		indexes = append(indexes, o.Index())
+	}
+	return indexes, nil
+}
+
+// getUserByLogin finds a user by a login qualified by its provider, as in gitlab:alice,
+// a login alone is a github one, the only provider borg had at first
+func getUserByLogin(db *gorm.DB, login string) (domain.User, error) {
+	provider := identity.Github
+	if i := strings.Index(login, ":"); i > 0 {
+		if _, ok := accountTypes[login[:i]]; ok {
+			provider, login = login[:i], login[i+1:]
+		}
+	}
+	u, err := domain.NewUserDao(db).GetByLoginAndAccountType(login, accountTypes[provider])
+	if err == gorm.ErrRecordNotFound {
+		return u, domain.NotFoundf("no %s user with login %s", provider, login)
+	}
+	return u, err
+}
+
+// providerOf returns the provider an account of this type was created with
+func providerOf(accountType string) string {
+	for provider, t := range accountTypes {
+		if t == accountType {
+			return provider
+		}
+	}
+	return ""
+}
+
+// SearchUsers returns the users whose login, name or email starts with q.
+// Users matching only by email are hidden from who cannot see their email.
+func (e Endpoints) SearchUsers(db *gorm.DB, viewerId string, q string) ([]domain.UserProfile, error) {
+	q = strings.TrimSpace(q)
+	if len(q) < minUserSearchLen {
+		return nil, domain.Invalidf("q must be at least %d characters long", minUserSearchLen)
+	}
+	// emails are matched in the query so that hidden ones do not take up the results
+	us, err := domain.NewUserDao(db).Search(q, viewerId, maxUserSearchResults)
+	if err != nil {
+		return nil, err
+	}
+	return userProfiles(db, viewerId, us)
+}
+
+// userProfiles shows the email of the viewer and of its co-members only
+func userProfiles(db *gorm.DB, viewerId string, us []domain.User) ([]domain.UserProfile, error) {
+	visible := map[string]bool{viewerId: viewerId != ""}
+	if viewerId != "" {
+		ids := []string{}
+		for _, u := range us {
+			ids = append(ids, u.Id)
+		}
+		coMembers, err := domain.NewUserOrganizationDao(db).FilterCoMembers(viewerId, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range coMembers {
+			visible[id] = true
+		}
+	}
+	profiles := []domain.UserProfile{}
+	for _, u := range us {
+		p := domain.UserProfile{
+			Id:        u.Id,
+			Login:     u.Login,
+			Provider:  providerOf(u.AccountType),
+			Name:      u.Name,
+			AvatarUrl: u.AvatarUrl,
+			CreatedAt: u.CreatedAt,
+		}
+		if visible[u.Id] {
+			p.Email = u.Email
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
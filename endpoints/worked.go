@@ -1,6 +1,9 @@
 package endpoints
 
 import (
+	"time"
+
+	"github.com/ok-borg/api/metrics"
 	"gopkg.in/olivere/elastic.v3"
 )
 
@@ -14,12 +17,17 @@ if (ctx._source.containsKey("worked")) {
 }
 `
 
+// counts the worked votes of a snippet, the queries are analyzed so their terms cannot be counted
+var workedCountScript = `_source.worked == null ? 0 : _source.worked.size()`
+
 // Worked tells the borg server that a result works for a given query
 func (e Endpoints) Worked(id, query string) error {
+	start := time.Now()
 	_, err := e.client.Update().
 		Index("borg").
 		Type("problem").
 		Id(id).Script(elastic.NewScriptInline(workedScript).Param("query", query)). // possibly injection?
 		Do()
+	metrics.ObserveEs("worked", start, err)
 	return err
 }
@@ -0,0 +1,102 @@
+package health
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	httpr "github.com/julienschmidt/httprouter"
+	"github.com/ok-borg/api/reqlog"
+	"github.com/ok-borg/api/v"
+	"gopkg.in/olivere/elastic.v3"
+)
+
+// build informations, set at link time with
+// -ldflags "-X github.com/ok-borg/api/health.Version=... -X github.com/ok-borg/api/health.Commit=..."
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// maximum time a dependency has to answer before the api is considered not ready
+const checkTimeout = 2 * time.Second
+
+var (
+	client *elastic.Client
+	db     *gorm.DB
+)
+
+func Init(r *httpr.Router, client_ *elastic.Client, db_ *gorm.DB) {
+	client = client_
+	db = db_
+
+	r.GET("/healthz", healthz)
+	r.GET("/readyz", readyz)
+	r.GET("/version", version)
+}
+
+// the process is alive if it can answer
+func healthz(w http.ResponseWriter, r *http.Request, p httpr.Params) {
+	common.WriteJsonResponse(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// the api is ready when both elastic search and mysql answer in time,
+// the reasons of a failure are only logged, they name hosts
+func readyz(w http.ResponseWriter, r *http.Request, p httpr.Params) {
+	errs := map[string]error{
+		"elasticsearch": withTimeout(pingEs),
+		"mysql":         withTimeout(pingDb),
+	}
+	checks := map[string]string{}
+	code := http.StatusOK
+	for name, err := range errs {
+		checks[name] = "ok"
+		if err != nil {
+			reqlog.Warnf(r.Context(), "[readyz] %s is not ready: %s", name, err.Error())
+			checks[name] = "fail"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	common.WriteJsonResponse(w, code, checks)
+}
+
+func version(w http.ResponseWriter, r *http.Request, p httpr.Params) {
+	common.WriteJsonResponse(w, http.StatusOK, map[string]string{
+		"version":   Version,
+		"commit":    Commit,
+		"buildDate": BuildDate,
+		"go":        runtime.Version(),
+	})
+}
+
+func pingEs() error {
+	res, err := client.ClusterHealth().Do()
+	if err != nil {
+		return err
+	}
+	if res.Status == "red" {
+		return errors.New("cluster status is red")
+	}
+	return nil
+}
+
+func pingDb() error {
+	return db.DB().Ping()
+}
+
+// neither the elastic v3 client nor gorm accept a context,
+// so run the check aside and give up after checkTimeout
+func withTimeout(check func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- check() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(checkTimeout):
+		return fmt.Errorf("timed out after %v", checkTimeout)
+	}
+}
@@ -0,0 +1,155 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	githubAuthURL  = "https://github.com/login/oauth/authorize"
+	githubTokenURL = "https://github.com/login/oauth/access_token"
+)
+
+type GithubProvider struct {
+	oauthCfg *oauth2.Config
+	// apiUrl replaces https://api.github.com/ when set, for github enterprise or a stub
+	apiUrl *url.URL
+}
+
+func NewGithubProvider(clientId, clientSecret, redirectUrl, apiUrl string) (*GithubProvider, error) {
+	var u *url.URL
+	if apiUrl != "" {
+		var err error
+		if u, err = url.Parse(strings.TrimSuffix(apiUrl, "/") + "/"); err != nil {
+			return nil, fmt.Errorf("invalid github api url %s: %s", apiUrl, err.Error())
+		}
+	}
+	return &GithubProvider{
+		apiUrl: u,
+		oauthCfg: &oauth2.Config{
+			ClientID:     clientId,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectUrl,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  githubAuthURL,
+				TokenURL: githubTokenURL,
+			},
+			Scopes: []string{"read:org"},
+		},
+	}, nil
+}
+
+func (g *GithubProvider) Name() string {
+	return Github
+}
+
+func (g *GithubProvider) AuthorizeURL(state string, req AuthRequest) (string, error) {
+	return g.oauthCfg.AuthCodeURL(state, authCodeOptions(req)...), nil
+}
+
+func (g *GithubProvider) Exchange(ctx context.Context, code string, req AuthRequest) (Identity, error) {
+	ctx = withHttpClient(ctx)
+	tkn, err := g.oauthCfg.Exchange(ctx, code, exchangeOptions(req)...)
+	if err != nil {
+		return Identity{}, fmt.Errorf("there was an issue getting your token: %v", err)
+	}
+	if !tkn.Valid() {
+		return Identity{}, errors.New("Retrieved invalid token")
+	}
+	return g.Profile(ctx, tkn)
+}
+
+func (g *GithubProvider) Profile(ctx context.Context, tkn *oauth2.Token) (Identity, error) {
+	user, _, err := g.Client(ctx, tkn).Users.Get("")
+	if err != nil {
+		return Identity{}, fmt.Errorf("error getting name: %v", err)
+	}
+	ret := Identity{
+		Provider:  Github,
+		Id:        fmt.Sprintf("%v", *user.ID),
+		Login:     *user.Login,
+		AvatarUrl: *user.AvatarURL,
+		Token:     tkn,
+	}
+	if user.Email != nil {
+		ret.Email = *user.Email
+	}
+	if user.Name != nil {
+		ret.Name = *user.Name
+	}
+	return ret, nil
+}
+
+// Refresh returns tkn unless the oauth app opted in to expiring tokens
+func (g *GithubProvider) Refresh(ctx context.Context, tkn *oauth2.Token) (*oauth2.Token, error) {
+	return g.oauthCfg.TokenSource(withHttpClient(ctx), tkn).Token()
+}
+
+// Client returns a github api client authenticated as the user owning tkn
+func (g *GithubProvider) Client(ctx context.Context, tkn *oauth2.Token) *github.Client {
+	c := github.NewClient(g.oauthCfg.Client(withHttpClient(ctx), tkn))
+	if g.apiUrl != nil {
+		c.BaseURL = g.apiUrl
+	}
+	return c
+}
+
+// Memberships lists the active github organizations of the user owning tkn,
+// with the teams the user is part of, this is what the read:org scope is for
+func (g *GithubProvider) Memberships(ctx context.Context, tkn *oauth2.Token) ([]Membership, error) {
+	c := g.Client(ctx, tkn)
+	byOrg := map[string]*Membership{}
+	ms := []*Membership{}
+	opt := &github.ListOrgMembershipsOptions{State: "active", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		page, res, err := c.Organizations.ListOrgMemberships(opt)
+		if err != nil {
+			return nil, fmt.Errorf("error listing github organizations: %v", err)
+		}
+		for _, m := range page {
+			if m.Organization == nil || m.Organization.Login == nil {
+				continue
+			}
+			login := strings.ToLower(*m.Organization.Login)
+			byOrg[login] = &Membership{
+				Organization: login,
+				Admin:        m.Role != nil && *m.Role == "admin",
+			}
+			ms = append(ms, byOrg[login])
+		}
+		if res.NextPage == 0 {
+			break
+		}
+		opt.Page = res.NextPage
+	}
+	topt := &github.ListOptions{PerPage: 100}
+	for {
+		teams, res, err := c.Organizations.ListUserTeams(topt)
+		if err != nil {
+			return nil, fmt.Errorf("error listing github teams: %v", err)
+		}
+		for _, t := range teams {
+			if t.Organization == nil || t.Organization.Login == nil || t.Slug == nil {
+				continue
+			}
+			if m, ok := byOrg[strings.ToLower(*t.Organization.Login)]; ok {
+				m.Teams = append(m.Teams, strings.ToLower(*t.Slug))
+			}
+		}
+		if res.NextPage == 0 {
+			break
+		}
+		topt.Page = res.NextPage
+	}
+	ret := []Membership{}
+	for _, m := range ms {
+		ret = append(ret, *m)
+	}
+	return ret, nil
+}
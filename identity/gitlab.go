@@ -0,0 +1,92 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+const gitlabDefaultURL = "https://gitlab.com"
+
+// GitlabProvider works with gitlab.com or a self-hosted instance
+type GitlabProvider struct {
+	baseUrl  string
+	oauthCfg *oauth2.Config
+}
+
+type gitlabUser struct {
+	Id        int64  `json:"id"`
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	AvatarUrl string `json:"avatar_url"`
+}
+
+func NewGitlabProvider(baseUrl, clientId, clientSecret, redirectUrl string) *GitlabProvider {
+	if baseUrl == "" {
+		baseUrl = gitlabDefaultURL
+	}
+	baseUrl = strings.TrimSuffix(baseUrl, "/")
+	return &GitlabProvider{
+		baseUrl: baseUrl,
+		oauthCfg: &oauth2.Config{
+			ClientID:     clientId,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectUrl,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  baseUrl + "/oauth/authorize",
+				TokenURL: baseUrl + "/oauth/token",
+			},
+			Scopes: []string{"read_user"},
+		},
+	}
+}
+
+func (g *GitlabProvider) Name() string {
+	return Gitlab
+}
+
+func (g *GitlabProvider) AuthorizeURL(state string, req AuthRequest) (string, error) {
+	return g.oauthCfg.AuthCodeURL(state, authCodeOptions(req)...), nil
+}
+
+func (g *GitlabProvider) Exchange(ctx context.Context, code string, req AuthRequest) (Identity, error) {
+	ctx = withHttpClient(ctx)
+	tkn, err := g.oauthCfg.Exchange(ctx, code, exchangeOptions(req)...)
+	if err != nil {
+		return Identity{}, fmt.Errorf("there was an issue getting your token: %v", err)
+	}
+	return g.Profile(ctx, tkn)
+}
+
+func (g *GitlabProvider) Refresh(ctx context.Context, tkn *oauth2.Token) (*oauth2.Token, error) {
+	return g.oauthCfg.TokenSource(withHttpClient(ctx), tkn).Token()
+}
+
+func (g *GitlabProvider) Profile(ctx context.Context, tkn *oauth2.Token) (Identity, error) {
+	res, err := g.oauthCfg.Client(withHttpClient(ctx), tkn).Get(g.baseUrl + "/api/v4/user")
+	if err != nil {
+		return Identity{}, fmt.Errorf("error getting gitlab user: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return Identity{}, fmt.Errorf("error getting gitlab user: status %d", res.StatusCode)
+	}
+	user := gitlabUser{}
+	if err := json.NewDecoder(res.Body).Decode(&user); err != nil {
+		return Identity{}, fmt.Errorf("invalid gitlab user: %v", err)
+	}
+	return Identity{
+		Provider:  Gitlab,
+		Id:        fmt.Sprintf("%d", user.Id),
+		Login:     user.Username,
+		Name:      user.Name,
+		Email:     user.Email,
+		AvatarUrl: user.AvatarUrl,
+		Token:     tkn,
+	}, nil
+}
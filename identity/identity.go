@@ -0,0 +1,106 @@
+package identity
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ok-borg/api/domain"
+	"golang.org/x/oauth2"
+)
+
+// names of the supported providers, also used in the urls
+const (
+	Github = domain.ProviderGithub
+	Gitlab = domain.ProviderGitlab
+	Oidc   = domain.ProviderOidc
+)
+
+// timeout of every call made to a provider
+const httpTimeout = 10 * time.Second
+
+// Identity is a user as known by an identity provider
+type Identity struct {
+	Provider string
+	// Issuer is only set for OpenID Connect identities
+	Issuer string
+	Id     string
+	Login  string
+	Name   string
+	// Email is empty unless the provider verified it
+	Email     string
+	AvatarUrl string
+	Token     *oauth2.Token
+}
+
+// AuthRequest holds the parameters of one login
+type AuthRequest struct {
+	// RedirectUrl overrides the redirect url configured for the provider
+	RedirectUrl string
+	// PKCE, the challenge is sent to the provider when redirecting the user,
+	// the verifier when exchanging the code
+	CodeChallenge string
+	CodeVerifier  string
+}
+
+// Provider signs users in with the oauth2 authorization code flow
+type Provider interface {
+	Name() string
+	// AuthorizeURL is where the user is redirected to log in
+	AuthorizeURL(state string, req AuthRequest) (string, error)
+	// Exchange trades the authorization code for the identity of the user
+	Exchange(ctx context.Context, code string, req AuthRequest) (Identity, error)
+	// Profile reads the identity of the user owning an already issued token
+	Profile(ctx context.Context, tkn *oauth2.Token) (Identity, error)
+	// Refresh returns tkn, or a new token when it expired and can be refreshed
+	Refresh(ctx context.Context, tkn *oauth2.Token) (*oauth2.Token, error)
+}
+
+// Membership is the membership of a user in an organization of a provider
+type Membership struct {
+	// Organization and Teams are lower cased
+	Organization string
+	Admin        bool
+	Teams        []string
+}
+
+// OrganizationsProvider is a provider knowing the organizations of its users
+type OrganizationsProvider interface {
+	Memberships(ctx context.Context, tkn *oauth2.Token) ([]Membership, error)
+}
+
+// Providers holds the configured providers by name
+type Providers map[string]Provider
+
+func (ps Providers) Add(p Provider) {
+	ps[p.Name()] = p
+}
+
+func authCodeOptions(req AuthRequest) []oauth2.AuthCodeOption {
+	opts := []oauth2.AuthCodeOption{}
+	if req.RedirectUrl != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("redirect_uri", req.RedirectUrl))
+	}
+	if req.CodeChallenge != "" {
+		opts = append(opts,
+			oauth2.SetAuthURLParam("code_challenge", req.CodeChallenge),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+	}
+	return opts
+}
+
+func exchangeOptions(req AuthRequest) []oauth2.AuthCodeOption {
+	opts := []oauth2.AuthCodeOption{}
+	if req.RedirectUrl != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("redirect_uri", req.RedirectUrl))
+	}
+	if req.CodeVerifier != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("code_verifier", req.CodeVerifier))
+	}
+	return opts
+}
+
+// withHttpClient makes the oauth2 package use a client with a timeout
+func withHttpClient(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Timeout: httpTimeout})
+}
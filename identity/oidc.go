@@ -0,0 +1,348 @@
+package identity
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// OidcProvider is a generic OpenID Connect provider configured from its discovery document.
+// The id token of a login is verified against the keys of the issuer and the identity
+// is read from the userinfo endpoint, so any issuer serving the standard endpoints
+// works, a local fake one included.
+type OidcProvider struct {
+	issuer       string
+	clientId     string
+	clientSecret string
+	redirectUrl  string
+
+	mtx       sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+type oidcClaims struct {
+	Issuer   string      `json:"iss"`
+	Subject  string      `json:"sub"`
+	Audience interface{} `json:"aud"`
+	Expiry   int64       `json:"exp"`
+}
+
+// hasAudience tells if aud, a string or a list of strings, contains clientId
+func (c oidcClaims) hasAudience(clientId string) bool {
+	switch aud := c.Audience.(type) {
+	case string:
+		return aud == clientId
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type oidcUserinfo struct {
+	Subject           string `json:"sub"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	Email             string `json:"email"`
+	// some issuers send the string "true"
+	EmailVerified interface{} `json:"email_verified"`
+	Picture       string      `json:"picture"`
+}
+
+// verifiedEmail returns the email of the user, empty when the issuer did not verify it
+func (i oidcUserinfo) verifiedEmail() string {
+	if i.EmailVerified == true || i.EmailVerified == "true" {
+		return i.Email
+	}
+	return ""
+}
+
+func NewOidcProvider(issuer, clientId, clientSecret, redirectUrl string) *OidcProvider {
+	return &OidcProvider{
+		issuer:       issuer,
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		redirectUrl:  redirectUrl,
+	}
+}
+
+func (o *OidcProvider) Name() string {
+	return Oidc
+}
+
+func (o *OidcProvider) AuthorizeURL(state string, req AuthRequest) (string, error) {
+	cfg, err := o.config(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return cfg.AuthCodeURL(state, authCodeOptions(req)...), nil
+}
+
+func (o *OidcProvider) Exchange(ctx context.Context, code string, req AuthRequest) (Identity, error) {
+	ctx = withHttpClient(ctx)
+	cfg, err := o.config(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+	tkn, err := cfg.Exchange(ctx, code, exchangeOptions(req)...)
+	if err != nil {
+		return Identity{}, fmt.Errorf("there was an issue getting your token: %v", err)
+	}
+	rawIdToken, _ := tkn.Extra("id_token").(string)
+	if rawIdToken == "" {
+		return Identity{}, errors.New("token response has no id_token")
+	}
+	claims, err := o.verifyIdToken(ctx, rawIdToken)
+	if err != nil {
+		return Identity{}, err
+	}
+	id, err := o.profile(ctx, cfg, tkn)
+	if err != nil {
+		return Identity{}, err
+	}
+	if id.Id != claims.Subject {
+		return Identity{}, errors.New("userinfo subject does not match the id_token")
+	}
+	return id, nil
+}
+
+func (o *OidcProvider) Profile(ctx context.Context, tkn *oauth2.Token) (Identity, error) {
+	ctx = withHttpClient(ctx)
+	cfg, err := o.config(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+	return o.profile(ctx, cfg, tkn)
+}
+
+func (o *OidcProvider) Refresh(ctx context.Context, tkn *oauth2.Token) (*oauth2.Token, error) {
+	ctx = withHttpClient(ctx)
+	cfg, err := o.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.TokenSource(ctx, tkn).Token()
+}
+
+func (o *OidcProvider) profile(ctx context.Context, cfg *oauth2.Config, tkn *oauth2.Token) (Identity, error) {
+	res, err := cfg.Client(ctx, tkn).Get(o.discovery.UserinfoEndpoint)
+	if err != nil {
+		return Identity{}, fmt.Errorf("error getting userinfo: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return Identity{}, fmt.Errorf("error getting userinfo: status %d", res.StatusCode)
+	}
+	info := oidcUserinfo{}
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return Identity{}, fmt.Errorf("invalid userinfo: %v", err)
+	}
+	if info.Subject == "" {
+		return Identity{}, errors.New("userinfo has no subject")
+	}
+	login := info.PreferredUsername
+	if login == "" && info.Email != "" {
+		login = strings.Split(info.Email, "@")[0]
+	}
+	// anyone can claim any email, invitations are matched on verified ones only
+	email := info.verifiedEmail()
+	if login == "" {
+		login = info.Subject
+	}
+	return Identity{
+		Provider:  Oidc,
+		Issuer:    o.discovery.Issuer,
+		Id:        info.Subject,
+		Login:     login,
+		Name:      info.Name,
+		Email:     email,
+		AvatarUrl: info.Picture,
+		Token:     tkn,
+	}, nil
+}
+
+// config fetches the discovery document on first use,
+// so the api can start while the issuer is unreachable
+func (o *OidcProvider) config(ctx context.Context) (*oauth2.Config, error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	if o.discovery == nil {
+		d, err := discover(withHttpClient(ctx), o.issuer)
+		if err != nil {
+			return nil, err
+		}
+		o.discovery = d
+	}
+	return &oauth2.Config{
+		ClientID:     o.clientId,
+		ClientSecret: o.clientSecret,
+		RedirectURL:  o.redirectUrl,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  o.discovery.AuthorizationEndpoint,
+			TokenURL: o.discovery.TokenEndpoint,
+		},
+		Scopes: []string{"openid", "profile", "email"},
+	}, nil
+}
+
+func discover(ctx context.Context, issuer string) (*oidcDiscovery, error) {
+	client := ctx.Value(oauth2.HTTPClient).(*http.Client)
+	res, err := client.Get(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch openid configuration: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch openid configuration: status %d", res.StatusCode)
+	}
+	d := oidcDiscovery{}
+	if err := json.NewDecoder(res.Body).Decode(&d); err != nil {
+		return nil, fmt.Errorf("invalid openid configuration: %v", err)
+	}
+	// the issuer is compared exactly as configured, a trailing slash included
+	if d.Issuer != issuer {
+		return nil, fmt.Errorf("openid configuration issuer %s does not match %s", d.Issuer, issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.UserinfoEndpoint == "" || d.JwksUri == "" {
+		return nil, errors.New("openid configuration is missing endpoints")
+	}
+	return &d, nil
+}
+
+// verifyIdToken checks the RS256 signature of raw against the keys of the issuer
+// and that the token was issued by it, for this client, and has not expired
+func (o *OidcProvider) verifyIdToken(ctx context.Context, raw string) (*oidcClaims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id_token")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed id_token header: %v", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported id_token algorithm %s", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed id_token signature: %v", err)
+	}
+	key, err := o.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, errors.New("invalid id_token signature")
+	}
+	claims := oidcClaims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed id_token claims: %v", err)
+	}
+	if claims.Issuer != o.discovery.Issuer {
+		return nil, fmt.Errorf("id_token issuer %s does not match %s", claims.Issuer, o.discovery.Issuer)
+	}
+	if !claims.hasAudience(o.clientId) {
+		return nil, errors.New("id_token was not issued for this client")
+	}
+	if time.Now().Unix() >= claims.Expiry {
+		return nil, errors.New("id_token has expired")
+	}
+	return &claims, nil
+}
+
+// key returns the signing key kid of the issuer,
+// the key set is fetched again when kid is unknown as issuers rotate keys
+func (o *OidcProvider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	if k, ok := o.keys[kid]; ok {
+		return k, nil
+	}
+	keys, err := fetchKeys(ctx, o.discovery.JwksUri)
+	if err != nil {
+		return nil, err
+	}
+	o.keys = keys
+	if k, ok := o.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown id_token key %s", kid)
+}
+
+func fetchKeys(ctx context.Context, jwksUri string) (map[string]*rsa.PublicKey, error) {
+	client := ctx.Value(oauth2.HTTPClient).(*http.Client)
+	res, err := client.Get(jwksUri)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch openid keys: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch openid keys: status %d", res.StatusCode)
+	}
+	jwks := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("invalid openid keys: %v", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid openid key %s: %v", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid openid key %s: %v", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
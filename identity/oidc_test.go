@@ -0,0 +1,197 @@
+package identity
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	fakeClientId = "borg-client"
+	fakeCode     = "the-code"
+	fakeVerifier = "the-verifier-of-the-code-challenge-of-this-test"
+	fakeSubject  = "1234"
+)
+
+// fakeIssuer is an OpenID Connect issuer issuing one code for fakeVerifier
+type fakeIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	// signer signs the id tokens, key unless a test forges them
+	signer *rsa.PrivateKey
+	claims map[string]interface{}
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIssuer{key: key, signer: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+			"userinfo_endpoint":      f.URL + "/userinfo",
+			"jwks_uri":               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != fakeCode || r.Form.Get("code_verifier") != fakeVerifier {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "the-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     f.idToken(t),
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer the-access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sub":                fakeSubject,
+			"preferred_username": "jane",
+			"email":              "jane@example.com",
+			"email_verified":     true,
+		})
+	})
+	f.Server = httptest.NewServer(mux)
+	f.claims = map[string]interface{}{
+		"iss": f.URL,
+		"sub": fakeSubject,
+		"aud": fakeClientId,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	return f
+}
+
+func (f *fakeIssuer) idToken(t *testing.T) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+	claims, _ := json.Marshal(f.claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, f.signer, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestOidcAuthorizeURL(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+	p := NewOidcProvider(f.URL, fakeClientId, "secret", "http://borg.test/callback")
+	u, err := p.AuthorizeURL("the-state", AuthRequest{CodeChallenge: challenge(fakeVerifier)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u, f.URL+"/authorize?") {
+		t.Fatalf("authorize url %s is not the discovered endpoint", u)
+	}
+	q := parsed.Query()
+	if q.Get("state") != "the-state" || q.Get("client_id") != fakeClientId {
+		t.Fatalf("unexpected authorize url %s", u)
+	}
+	if q.Get("code_challenge") != challenge(fakeVerifier) || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorize url %s has no pkce challenge", u)
+	}
+}
+
+func TestOidcExchange(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+	p := NewOidcProvider(f.URL, fakeClientId, "secret", "http://borg.test/callback")
+	id, err := p.Exchange(context.Background(), fakeCode, AuthRequest{CodeVerifier: fakeVerifier})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Provider != Oidc || id.Issuer != f.URL || id.Id != fakeSubject {
+		t.Fatalf("unexpected identity %+v", id)
+	}
+	if id.Login != "jane" || id.Email != "jane@example.com" {
+		t.Fatalf("unexpected identity %+v", id)
+	}
+}
+
+func TestOidcExchangeRejectsWrongVerifier(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+	p := NewOidcProvider(f.URL, fakeClientId, "secret", "http://borg.test/callback")
+	if _, err := p.Exchange(context.Background(), fakeCode, AuthRequest{CodeVerifier: "another-verifier"}); err == nil {
+		t.Fatal("code exchanged with the wrong verifier")
+	}
+}
+
+func TestOidcExchangeRejectsForgedIdToken(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+	forger, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.signer = forger
+	p := NewOidcProvider(f.URL, fakeClientId, "secret", "http://borg.test/callback")
+	_, err = p.Exchange(context.Background(), fakeCode, AuthRequest{CodeVerifier: fakeVerifier})
+	if err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("forged id_token accepted, error: %v", err)
+	}
+}
+
+func TestOidcExchangeRejectsInvalidClaims(t *testing.T) {
+	tests := map[string]func(claims map[string]interface{}){
+		"issuer":   func(c map[string]interface{}) { c["iss"] = "http://another.test" },
+		"audience": func(c map[string]interface{}) { c["aud"] = []string{"another-client"} },
+		"expiry":   func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"subject":  func(c map[string]interface{}) { c["sub"] = "5678" },
+	}
+	for name, tamper := range tests {
+		f := newFakeIssuer(t)
+		tamper(f.claims)
+		p := NewOidcProvider(f.URL, fakeClientId, "secret", "http://borg.test/callback")
+		if _, err := p.Exchange(context.Background(), fakeCode, AuthRequest{CodeVerifier: fakeVerifier}); err == nil {
+			t.Errorf("id_token with invalid %s accepted", name)
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,89 @@
+package identity
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ok-borg/api/secrets"
+)
+
+// how long a user has to come back from the provider
+const stateTtl = 10 * time.Minute
+
+// State is the oauth2 state parameter, signed so it cannot be forged
+// and bound to the client by the PKCE challenge only this client can answer
+type State struct {
+	Provider      string `json:"p"`
+	RedirectUrl   string `json:"r,omitempty"`
+	CodeChallenge string `json:"c"`
+	ExpiresAt     int64  `json:"e"`
+	Nonce         string `json:"n"`
+}
+
+// NewState returns a signed state for a login starting now
+func NewState(provider string, redirectUrl string, codeChallenge string) (string, error) {
+	nonce, err := secrets.NewToken()
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(State{
+		Provider:      provider,
+		RedirectUrl:   redirectUrl,
+		CodeChallenge: codeChallenge,
+		ExpiresAt:     time.Now().Add(stateTtl).Unix(),
+		Nonce:         nonce,
+	})
+	if err != nil {
+		return "", err
+	}
+	sig, err := secrets.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + sig, nil
+}
+
+// VerifyState checks the signature and expiry of a state issued for provider
+// and that codeVerifier answers its challenge
+func VerifyState(raw string, provider string, codeVerifier string) (State, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 2 {
+		return State{}, errors.New("malformed state")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return State{}, errors.New("malformed state")
+	}
+	if !secrets.Verify(payload, parts[1]) {
+		return State{}, errors.New("invalid state signature")
+	}
+	st := State{}
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return State{}, errors.New("malformed state")
+	}
+	if st.Provider != provider {
+		return State{}, errors.New("state was issued for another provider")
+	}
+	if time.Now().Unix() > st.ExpiresAt {
+		return State{}, errors.New("state has expired")
+	}
+	if codeVerifier == "" || CodeChallenge(codeVerifier) != st.CodeChallenge {
+		return State{}, errors.New("state was issued to another client")
+	}
+	return st, nil
+}
+
+// NewCodeVerifier returns a random PKCE verifier
+func NewCodeVerifier() (string, error) {
+	return secrets.NewToken()
+}
+
+// CodeChallenge returns the S256 PKCE challenge of a verifier
+func CodeChallenge(codeVerifier string) string {
+	h := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
@@ -4,8 +4,9 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
 	"time"
 
 	"gopkg.in/olivere/elastic.v3"
@@ -15,86 +16,119 @@ import (
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 	"github.com/jpillora/go-ogle-analytics"
 	httpr "github.com/julienschmidt/httprouter"
+	"github.com/ok-borg/api/access"
 	"github.com/ok-borg/api/conf"
 	"github.com/ok-borg/api/endpoints"
+	"github.com/ok-borg/api/health"
+	"github.com/ok-borg/api/identity"
+	"github.com/ok-borg/api/metrics"
+	"github.com/ok-borg/api/notify"
+	"github.com/ok-borg/api/reqlog"
+	"github.com/ok-borg/api/secrets"
 	"github.com/ok-borg/api/sitemap"
 	"github.com/ok-borg/api/v"
 	"github.com/ok-borg/api/v/v1"
 	"github.com/ok-borg/api/v/v2"
 	"github.com/rs/cors"
-	"golang.org/x/oauth2"
-)
-
-const (
-	githubTokenURL = "https://github.com/login/oauth/access_token"
 )
 
 var (
-	esAddr             = flag.String("esaddr", "127.0.0.1:9200", "Elastic Search address")
+	confPath           = flag.String("config", ".borg.conf.json", "Path of the json configuration file")
+	printConfig        = flag.Bool("print-config", false, "Print the effective configuration with secrets redacted and exit")
+	esAddr             = flag.String("esaddr", conf.Default().EsAddr, "Elastic Search address")
 	githubClientId     = flag.String("github-client-id", "", "Github oauth client id")
-	githubClientSecret = flag.String("github-client-secret", "", "Github client secret")
+	githubClientSecret = flag.String("github-client-secret", "", "Github client secret (prefer BORG_GITHUB_CLIENT_SECRET)")
 	sm                 = flag.String("sitemap", "", "Sitemap location. Leave empty if you don't want a sitemap to be generated")
 	analytics          = flag.String("analytics", "", "Analytics tracking id")
-	sqlAddr            = flag.String("sqladdr", "127.0.0.1:3306", "Mysql address")
-	sqlIds             = flag.String("sqlids", "root:root", "Mysql identifier")
+	sqlAddr            = flag.String("sqladdr", conf.Default().Mysql.Addr, "Mysql address")
+	sqlIds             = flag.String("sqlids", conf.Default().Mysql.Ids, "Mysql identifier (prefer BORG_MYSQL_IDS)")
+	port               = flag.Int("port", conf.Default().Port, "Http server port")
+	maxCreate          = flag.Int("max-create", conf.Default().RateLimit.MaxCreate, "Maximum snippets created per token in 24 hours")
+	maxUpdate          = flag.Int("max-update", conf.Default().RateLimit.MaxUpdate, "Maximum snippets updated per token in 24 hours")
+	tokenTtl           = flag.Int("token-ttl", conf.Default().Auth.TokenTtl, "Hours before an access token expires")
+	logFormat          = flag.String("log-format", conf.Default().Log.Format, "Log output format, text or json")
+	logLevel           = flag.String("log-level", conf.Default().Log.Level, "Minimum log level")
 )
 
 var (
+	cfg             conf.Conf
 	client          *elastic.Client
 	analyticsClient *ga.Client
 	ep              *endpoints.Endpoints
 	db              *gorm.DB
 )
 
-func initWithConfFile() {
-	// let's assume the conf file is in the same place than the benary.
-	c, err := ioutil.ReadFile(".borg.conf.json")
-	if err != nil {
-		// just return, there is probably not configuration file
-		return
-	}
-
-	var conf conf.Conf
-	if err := json.Unmarshal(c, &conf); err != nil {
-		panic(fmt.Sprintf("[initWithConfFile] invalid config format: %s", err.Error()))
-	}
+// only the flags explicitly set on the cmdline overwrite the config
+func applyFlags(c *conf.Conf) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "esaddr":
+			c.EsAddr = *esAddr
+		case "github-client-id":
+			c.Github.ClientId = *githubClientId
+		case "github-client-secret":
+			c.Github.ClientSecret = *githubClientSecret
+		case "sitemap":
+			c.Sitemap = *sm
+		case "analytics":
+			c.Analytics = *analytics
+		case "sqladdr":
+			c.Mysql.Addr = *sqlAddr
+		case "sqlids":
+			c.Mysql.Ids = *sqlIds
+		case "port":
+			c.Port = *port
+		case "max-create":
+			c.RateLimit.MaxCreate = *maxCreate
+		case "max-update":
+			c.RateLimit.MaxUpdate = *maxUpdate
+		case "token-ttl":
+			c.Auth.TokenTtl = *tokenTtl
+		case "log-format":
+			c.Log.Format = *logFormat
+		case "log-level":
+			c.Log.Level = *logLevel
+		}
+	})
+}
 
-	if conf.EsAddr != "" {
-		*esAddr = conf.EsAddr
-	}
-	if conf.Github.ClientId != "" {
-		*githubClientId = conf.Github.ClientId
+func init() {
+	flag.Parse()
+	// precedence is defaults < config file < environment < cmdline
+	cfg = conf.Default()
+	if err := cfg.LoadFile(*confPath); err != nil {
+		panic(fmt.Sprintf("[init] %s", err.Error()))
 	}
-	if conf.Github.ClientSecret != "" {
-		*githubClientSecret = conf.Github.ClientSecret
+	if err := cfg.LoadEnv(); err != nil {
+		panic(fmt.Sprintf("[init] %s", err.Error()))
 	}
-	if conf.Sitemap != "" {
-		*sm = conf.Sitemap
+	applyFlags(&cfg)
+
+	if *printConfig {
+		bs, _ := json.MarshalIndent(cfg.Redacted(), "", "  ")
+		fmt.Println(string(bs))
+		os.Exit(0)
 	}
-	if conf.Analytics != "" {
-		*analytics = conf.Analytics
+	if err := cfg.Validate(); err != nil {
+		panic(fmt.Sprintf("[init] %s", err.Error()))
 	}
-	if conf.Mysql.Addr != "" {
-		*sqlAddr = conf.Mysql.Addr
+
+	if err := conf.InitLogger(cfg.Log); err != nil {
+		panic(fmt.Sprintf("[init] %s", err.Error()))
 	}
-	if conf.Mysql.Ids != "" {
-		*sqlIds = conf.Mysql.Ids
+	reqlog.Init(cfg.Log.Format == conf.LogFormatJson)
+	if err := secrets.Init(cfg.Auth.SecretKey); err != nil {
+		panic(fmt.Sprintf("[init] %s", err.Error()))
 	}
-}
+	access.Init(cfg.RateLimit.MaxCreate, cfg.RateLimit.MaxUpdate)
 
-func init() {
-	// read config file before if it exists, so we can replaces the var that was set with the cmdline
-	// the cmdline is allowed to overwrite the config file.
-	initWithConfFile()
-	flag.Parse()
-
-	cl, err := elastic.NewClient(elastic.SetSniff(false), elastic.SetURL(fmt.Sprintf("http://%v", *esAddr)))
+	cl, err := elastic.NewClient(elastic.SetSniff(false), elastic.SetURL(fmt.Sprintf("http://%v", cfg.EsAddr)))
 	if err != nil {
 		panic(err)
 	}
 	client = cl
-	if len(*analytics) > 0 {
-		acl, err := ga.NewClient(*analytics)
+	if len(cfg.Analytics) > 0 {
+		acl, err := ga.NewClient(cfg.Analytics)
 		if err != nil {
 			log.Errorf("Failed to acquire analytics client id: %v", err)
 		}
@@ -102,38 +136,101 @@ func init() {
 	}
 }
 
-func main() {
-	oauthCfg := &oauth2.Config{
-		ClientID:     *githubClientId,
-		ClientSecret: *githubClientSecret,
-		Endpoint: oauth2.Endpoint{
-			TokenURL: githubTokenURL,
-		},
-		Scopes: []string{"read:org"},
+// mails go through smtp when it is configured, else they are written as files
+func notifier(c conf.Mail) notify.Notifier {
+	if c.SmtpAddr != "" {
+		return notify.NewSmtpNotifier(c.SmtpAddr, c.From, c.Username, c.Password)
 	}
+	return notify.NewFileNotifier(c.Dir)
+}
+
+// a provider is enabled as soon as it is configured
+func identityProviders(c conf.Conf) identity.Providers {
+	providers := identity.Providers{}
+	if c.Github.ClientId != "" {
+		gh, err := identity.NewGithubProvider(
+			c.Github.ClientId, c.Github.ClientSecret, c.Github.RedirectUrl, c.Github.ApiUrl)
+		if err != nil {
+			panic(fmt.Sprintf("[init] %s", err.Error()))
+		}
+		providers.Add(gh)
+	}
+	if c.Gitlab.ClientId != "" {
+		providers.Add(identity.NewGitlabProvider(
+			c.Gitlab.BaseUrl, c.Gitlab.ClientId, c.Gitlab.ClientSecret, c.Gitlab.RedirectUrl))
+	}
+	if c.Oidc.Issuer != "" {
+		providers.Add(identity.NewOidcProvider(
+			c.Oidc.Issuer, c.Oidc.ClientId, c.Oidc.ClientSecret, c.Oidc.RedirectUrl))
+	}
+	return providers
+}
+
+// allowedOrigins returns the origins of the redirect urls of the providers and of auth.redirect_urls
+func allowedOrigins(c conf.Conf) []string {
+	origins := []string{}
+	seen := map[string]bool{}
+	for _, u := range append([]string{c.Github.RedirectUrl, c.Gitlab.RedirectUrl, c.Oidc.RedirectUrl}, c.Auth.RedirectUrls...) {
+		parsed, err := url.Parse(u)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			continue
+		}
+		origin := parsed.Scheme + "://" + parsed.Host
+		if !seen[origin] {
+			seen[origin] = true
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+func main() {
 
 	// init mysql
 	var err error
-	dsn := fmt.Sprintf("%s@tcp(%s)/borg?parseTime=True", *sqlIds, *sqlAddr)
+	dsn := fmt.Sprintf("%s@tcp(%s)/borg?parseTime=True", cfg.Mysql.Ids, cfg.Mysql.Addr)
 	if db, err = gorm.Open("mysql", dsn); err != nil {
 		panic(fmt.Sprintf("[init] unable to initialize gorm: %s", err.Error()))
 	}
 	defer db.Close()
+	metrics.InstrumentDb(db)
 
-	ep = endpoints.NewEndpoints(oauthCfg, client, analyticsClient, db)
+	ep = endpoints.NewEndpoints(
+		identityProviders(cfg), client, analyticsClient, db,
+		time.Duration(cfg.Auth.TokenTtl)*time.Hour, cfg.Auth.RedirectUrls,
+		time.Duration(cfg.Organizations.DeletionGracePeriod)*time.Hour, notifier(cfg.Mail))
 	r := httpr.New()
-	if len(*sm) > 0 {
-		go sitemapLoop(*sm, client)
+	if len(cfg.Sitemap) > 0 {
+		go sitemapLoop(cfg.Sitemap, client)
+	}
+	if cfg.Auth.ProfileSyncInterval > 0 {
+		go ep.SyncProfilesEvery(time.Duration(cfg.Auth.ProfileSyncInterval) * time.Hour)
+	}
+	go ep.PurgeOrganizationsEvery(time.Hour)
+	if cfg.Github.OrgSyncInterval > 0 && cfg.Github.ClientId != "" {
+		go ep.SyncGithubOrganizationsEvery(time.Duration(cfg.Github.OrgSyncInterval) * time.Hour)
 	}
 
 	// decl routes
-	common.Init(client, analyticsClient, ep, db, *githubClientId)
-	v1.Init(r, client, analyticsClient, ep, db)
-	v2.Init(r, client, analyticsClient, ep, db)
-
-	handler := cors.New(cors.Options{AllowedHeaders: []string{"*"}, AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"}}).Handler(r)
+	common.Init(client, analyticsClient, ep, db)
+	mr := metrics.NewRouter(r)
+	v1.Init(mr, client, analyticsClient, ep, db)
+	v2.Init(mr, client, analyticsClient, ep, db)
+	health.Init(r, client, db)
+	metrics.Init(r)
+
+	// the login reads the PKCE cookie set by the authorize redirect,
+	// credentials are only allowed from the sites the users are sent back to
+	origins := allowedOrigins(cfg)
+	handler := reqlog.Middleware(cors.New(cors.Options{
+		AllowedOrigins:   origins,
+		AllowedHeaders:   []string{"*"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
+		ExposedHeaders:   []string{reqlog.HeaderRequestId},
+		AllowCredentials: len(origins) > 0,
+	}).Handler(r))
 	log.Info("Starting http server")
-	log.Critical(http.ListenAndServe(fmt.Sprintf(":%v", 9992), handler))
+	log.Critical(http.ListenAndServe(fmt.Sprintf(":%v", cfg.Port), handler))
 }
 
 func sitemapLoop(path string, client *elastic.Client) {
@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	httpr "github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "borg_http_requests_total",
+		Help: "Number of http requests by route and status code.",
+	}, []string{"method", "route", "code"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "borg_http_request_duration_seconds",
+		Help:    "Latency of http requests by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	searchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "borg_search_duration_seconds",
+		Help:    "Latency of search queries.",
+		Buckets: prometheus.DefBuckets,
+	})
+	searchHits = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "borg_search_hits",
+		Help:    "Number of results returned by search queries.",
+		Buckets: []float64{0, 1, 2, 5, 10, 20, 50},
+	})
+	searchZeroResults = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "borg_search_zero_results_total",
+		Help: "Number of search queries which returned no results.",
+	})
+
+	esDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "borg_es_request_duration_seconds",
+		Help:    "Latency of elastic search calls by operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"op"})
+	esErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "borg_es_errors_total",
+		Help: "Number of failed elastic search calls by operation.",
+	}, []string{"op"})
+
+	mysqlDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "borg_mysql_query_duration_seconds",
+		Help:    "Latency of mysql queries by operation and table.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"op", "table"})
+	mysqlErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "borg_mysql_errors_total",
+		Help: "Number of failed mysql queries by operation and table.",
+	}, []string{"op", "table"})
+
+	accessRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "borg_access_rejections_total",
+		Help: "Number of writes rejected by the access control by kind.",
+	}, []string{"kind"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		httpRequests, httpDuration,
+		searchDuration, searchHits, searchZeroResults,
+		esDuration, esErrors,
+		mysqlDuration, mysqlErrors,
+		accessRejections,
+	)
+}
+
+// Init exposes the metrics in prometheus text format on /metrics
+func Init(r *httpr.Router) {
+	r.Handler("GET", "/metrics", promhttp.Handler())
+}
+
+// Router registers routes on an httprouter.Router and instruments each one of them,
+// the route pattern is used as label so metrics cardinality stays low
+type Router struct {
+	*httpr.Router
+}
+
+func NewRouter(r *httpr.Router) *Router {
+	return &Router{Router: r}
+}
+
+func (r *Router) GET(path string, handle httpr.Handle) {
+	r.Router.GET(path, instrument("GET", path, handle))
+}
+
+func (r *Router) POST(path string, handle httpr.Handle) {
+	r.Router.POST(path, instrument("POST", path, handle))
+}
+
+func (r *Router) PUT(path string, handle httpr.Handle) {
+	r.Router.PUT(path, instrument("PUT", path, handle))
+}
+
+func (r *Router) DELETE(path string, handle httpr.Handle) {
+	r.Router.DELETE(path, instrument("DELETE", path, handle))
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func instrument(method string, route string, handle httpr.Handle) httpr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httpr.Params) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		handle(sw, r, p)
+		httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+		httpRequests.WithLabelValues(method, route, strconv.Itoa(sw.status)).Inc()
+	}
+}
+
+// ObserveSearch records the latency and the number of results of a search query
+func ObserveSearch(start time.Time, hits int) {
+	searchDuration.Observe(time.Since(start).Seconds())
+	searchHits.Observe(float64(hits))
+	if hits == 0 {
+		searchZeroResults.Inc()
+	}
+}
+
+// ObserveEs records the latency and the failure of an elastic search call
+func ObserveEs(op string, start time.Time, err error) {
+	esDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		esErrors.WithLabelValues(op).Inc()
+	}
+}
+
+// AccessRejected records a write rejected by the access control
+func AccessRejected(kind string) {
+	accessRejections.WithLabelValues(kind).Inc()
+}
+
+const startKey = "metrics:start"
+
+// InstrumentDb registers gorm callbacks timing every mysql query
+func InstrumentDb(db *gorm.DB) {
+	cb := db.Callback()
+	cb.Create().Before("gorm:create").Register("metrics:before_create", before)
+	cb.Create().After("gorm:create").Register("metrics:after_create", after("create"))
+	cb.Query().Before("gorm:query").Register("metrics:before_query", before)
+	cb.Query().After("gorm:query").Register("metrics:after_query", after("query"))
+	cb.Update().Before("gorm:update").Register("metrics:before_update", before)
+	cb.Update().After("gorm:update").Register("metrics:after_update", after("update"))
+	cb.Delete().Before("gorm:delete").Register("metrics:before_delete", before)
+	cb.Delete().After("gorm:delete").Register("metrics:after_delete", after("delete"))
+	cb.RowQuery().Before("gorm:row_query").Register("metrics:before_row_query", before)
+	cb.RowQuery().After("gorm:row_query").Register("metrics:after_row_query", after("row_query"))
+}
+
+func before(scope *gorm.Scope) {
+	scope.InstanceSet(startKey, time.Now())
+}
+
+func after(op string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		start, ok := scope.InstanceGet(startKey)
+		if !ok {
+			return
+		}
+		table := scope.TableName()
+		mysqlDuration.WithLabelValues(op, table).Observe(time.Since(start.(time.Time)).Seconds())
+		// not found is part of the normal flow of the daos
+		if err := scope.DB().Error; err != nil && err != gorm.ErrRecordNotFound {
+			mysqlErrors.WithLabelValues(op, table).Inc()
+		}
+	}
+}
@@ -0,0 +1,38 @@
+USE borg;
+
+-- members are owner, admin, editor or viewer instead of admin or not
+ALTER TABLE user_organizations
+      ADD COLUMN role VARCHAR(16) DEFAULT 'editor' NOT NULL AFTER organization_id;
+
+UPDATE user_organizations SET role = 'admin' WHERE is_admin = 1;
+
+-- the creator owns its organization when still an admin of it
+UPDATE user_organizations uo
+       JOIN organizations o ON o.id = uo.organization_id AND o.created_by = uo.user_id
+       SET uo.role = 'owner'
+       WHERE uo.role = 'admin';
+
+-- otherwise the oldest admin does
+UPDATE user_organizations
+       SET role = 'owner'
+       WHERE id IN (
+             SELECT id FROM (
+                    SELECT MIN(a.id) AS id
+                    FROM user_organizations a
+                    JOIN (
+                         SELECT organization_id, MIN(created_at) AS created_at
+                         FROM user_organizations
+                         WHERE role = 'admin'
+                         GROUP BY organization_id
+                    ) oldest ON oldest.organization_id = a.organization_id AND oldest.created_at = a.created_at
+                    WHERE a.role = 'admin'
+                    AND a.organization_id NOT IN (
+                        SELECT organization_id FROM (
+                               SELECT organization_id FROM user_organizations WHERE role = 'owner'
+                        ) owned
+                    )
+                    GROUP BY a.organization_id
+             ) heirs
+       );
+
+ALTER TABLE user_organizations DROP COLUMN is_admin;
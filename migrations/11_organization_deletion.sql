@@ -0,0 +1,7 @@
+USE borg;
+
+-- deleted organizations wait for a grace period before being purged
+
+ALTER TABLE organizations
+      ADD COLUMN deletes_at DATETIME NULL,
+      ADD INDEX organizations_deletes_at (deletes_at);
@@ -0,0 +1,16 @@
+USE borg;
+
+-- organizations have several join links, each with its own limits,
+-- and memberships remember the link they were created with.
+-- links are revoked instead of deleted so memberships keep pointing to them
+
+ALTER TABLE organization_join_links
+      ADD COLUMN name VARCHAR(255) DEFAULT '' NOT NULL AFTER organization_id,
+      ADD COLUMN max_uses INTEGER DEFAULT 0 NOT NULL AFTER ttl,
+      ADD COLUMN uses INTEGER DEFAULT 0 NOT NULL AFTER max_uses,
+      ADD COLUMN role VARCHAR(16) DEFAULT 'editor' NOT NULL AFTER uses,
+      ADD COLUMN revoked_at DATETIME NULL AFTER role;
+
+ALTER TABLE user_organizations
+      ADD COLUMN join_link_id VARCHAR(36) NULL AFTER role,
+      ADD FOREIGN KEY (join_link_id) REFERENCES organization_join_links (id);
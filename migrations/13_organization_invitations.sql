@@ -0,0 +1,25 @@
+USE borg;
+
+-- invitations of a github login or an email address to an organization
+
+CREATE TABLE IF NOT EXISTS organization_invitations
+(
+  id              VARCHAR(36)                         NOT NULL,
+  organization_id VARCHAR(36)                         NOT NULL,
+  login           VARCHAR(255) DEFAULT ''             NOT NULL,
+  email           VARCHAR(255) DEFAULT ''             NOT NULL,
+  role            VARCHAR(16)                         NOT NULL,
+  status          VARCHAR(16) DEFAULT 'pending'       NOT NULL,
+  created_at      DATETIME DEFAULT CURRENT_TIMESTAMP  NOT NULL,
+  updated_at      DATETIME DEFAULT CURRENT_TIMESTAMP  NOT NULL,
+  created_by      VARCHAR(36)                         NOT NULL,
+  updated_by      VARCHAR(36)                         NOT NULL,
+  PRIMARY KEY (id),
+  INDEX organization_invitations_login (login, status),
+  INDEX organization_invitations_email (email, status)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+ALTER TABLE organization_invitations
+      ADD FOREIGN KEY (organization_id) REFERENCES organizations (id),
+      ADD FOREIGN KEY (created_by) REFERENCES users (id),
+      ADD FOREIGN KEY (updated_by) REFERENCES users (id);
@@ -0,0 +1,12 @@
+USE borg;
+
+-- organizations linked to a github organization, or one of its teams,
+-- get their members from github
+
+ALTER TABLE organizations
+      ADD COLUMN github_org VARCHAR(255) DEFAULT '' NOT NULL,
+      ADD COLUMN github_team VARCHAR(255) DEFAULT '' NOT NULL;
+
+ALTER TABLE user_organizations
+      ADD COLUMN source VARCHAR(16) DEFAULT '' NOT NULL AFTER join_link_id,
+      ADD INDEX user_organizations_source (source);
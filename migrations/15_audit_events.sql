@@ -0,0 +1,20 @@
+USE borg;
+
+-- what members did in their organizations
+
+CREATE TABLE IF NOT EXISTS audit_events
+(
+  id              VARCHAR(36)                         NOT NULL,
+  organization_id VARCHAR(36)                         NOT NULL,
+  actor_id        VARCHAR(36)                         NOT NULL,
+  action          VARCHAR(64)                         NOT NULL,
+  target_id       VARCHAR(64) DEFAULT ''              NOT NULL,
+  details         TEXT                                NULL,
+  created_at      DATETIME DEFAULT CURRENT_TIMESTAMP  NOT NULL,
+  PRIMARY KEY (id),
+  INDEX audit_events_organization (organization_id, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+ALTER TABLE audit_events
+      ADD FOREIGN KEY (organization_id) REFERENCES organizations (id),
+      ADD FOREIGN KEY (actor_id) REFERENCES users (id);
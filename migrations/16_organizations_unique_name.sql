@@ -0,0 +1,21 @@
+USE borg;
+
+-- the names of organizations are unique, the index settles concurrent creations.
+-- A unique index on utf8 is at most 255 characters long.
+
+UPDATE organizations
+   SET name = CONCAT(LEFT(name, 240), ' (', LEFT(id, 8), ')')
+ WHERE CHAR_LENGTH(name) > 255;
+
+-- the oldest organization keeps its name, the others get their id appended
+
+UPDATE organizations o
+  JOIN organizations older
+    ON older.name = o.name
+   AND (older.created_at < o.created_at
+        OR (older.created_at = o.created_at AND older.id < o.id))
+   SET o.name = CONCAT(LEFT(o.name, 240), ' (', LEFT(o.id, 8), ')');
+
+ALTER TABLE organizations
+      MODIFY name VARCHAR(255) NOT NULL,
+      ADD UNIQUE INDEX organizations_name (name);
@@ -0,0 +1,16 @@
+USE borg;
+
+-- gitlab and most openid connect tokens expire within hours,
+-- the refresh token lets the profile sync get a new one
+
+ALTER TABLE github_users
+      ADD COLUMN encrypted_refresh_token VARCHAR(4096) DEFAULT '' NOT NULL,
+      ADD COLUMN token_expires_at DATETIME NULL;
+
+ALTER TABLE gitlab_users
+      ADD COLUMN encrypted_refresh_token VARCHAR(4096) DEFAULT '' NOT NULL,
+      ADD COLUMN token_expires_at DATETIME NULL;
+
+ALTER TABLE oidc_users
+      ADD COLUMN encrypted_refresh_token VARCHAR(4096) DEFAULT '' NOT NULL,
+      ADD COLUMN token_expires_at DATETIME NULL;
@@ -0,0 +1,6 @@
+USE borg;
+
+-- the emails of openid connect users were kept whether the issuer verified them or not,
+-- they come back at the next login or profile sync when they are verified
+
+UPDATE users SET email = '' WHERE account_type = 'OIDC';
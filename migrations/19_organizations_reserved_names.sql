@@ -0,0 +1,7 @@
+USE borg;
+
+-- "borg" is the owner of the public snippets, organizations named so get their id appended
+
+UPDATE organizations
+   SET name = CONCAT(name, ' (', LEFT(id, 8), ')')
+ WHERE BINARY name = 'borg';
@@ -0,0 +1,17 @@
+USE borg;
+
+-- access tokens used to be the github oauth tokens themselves,
+-- they are now issued by borg and only their hash is stored.
+-- existing tokens cannot be hashed back into borg tokens, users have to login again.
+DELETE FROM access_tokens;
+
+ALTER TABLE access_tokens
+      DROP COLUMN token,
+      ADD COLUMN token_hash   VARCHAR(64)   NOT NULL AFTER id,
+      ADD COLUMN expires_at   DATETIME      NULL     AFTER user_id,
+      ADD COLUMN last_used_at DATETIME      NULL     AFTER expires_at,
+      ADD UNIQUE INDEX access_tokens_token_hash (token_hash);
+
+-- the github token is kept encrypted, only to sync organizations
+ALTER TABLE github_users
+      ADD COLUMN encrypted_token VARCHAR(1024) DEFAULT '' NOT NULL AFTER borg_user_id;
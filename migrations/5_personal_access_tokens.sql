@@ -0,0 +1,9 @@
+USE borg;
+
+-- tokens issued at login are session tokens with every scope,
+-- personal tokens are created by the users for the cli and automation.
+ALTER TABLE access_tokens
+      ADD COLUMN name        VARCHAR(255)                            DEFAULT ''  NOT NULL AFTER token_hash,
+      ADD COLUMN scopes      VARCHAR(255) DEFAULT 'read,write,org-admin'          NOT NULL AFTER name,
+      ADD COLUMN is_personal TINYINT                                 DEFAULT 0   NOT NULL AFTER scopes,
+      ADD INDEX access_tokens_user_id_is_personal (user_id, is_personal);
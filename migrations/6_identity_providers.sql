@@ -0,0 +1,37 @@
+USE borg;
+
+-- every identity provider links its users to borg users in its own table
+
+ALTER TABLE github_users
+      ADD UNIQUE INDEX github_users_github_id (github_id);
+
+CREATE TABLE IF NOT EXISTS gitlab_users
+(
+  id                  VARCHAR(36)                                                     NOT NULL,
+  gitlab_id           VARCHAR(36)                                                     NOT NULL,
+  borg_user_id        VARCHAR(36)                                                     NOT NULL,
+  encrypted_token     VARCHAR(1024) DEFAULT ''                                        NOT NULL,
+  created_at          DATETIME DEFAULT CURRENT_TIMESTAMP                              NOT NULL,
+  updated_at          DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP  NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE INDEX gitlab_users_gitlab_id (gitlab_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+ALTER TABLE gitlab_users
+      ADD FOREIGN KEY (borg_user_id) REFERENCES users (id);
+
+CREATE TABLE IF NOT EXISTS oidc_users
+(
+  id                  VARCHAR(36)                                                     NOT NULL,
+  issuer              VARCHAR(255)                                                    NOT NULL,
+  subject             VARCHAR(255)                                                    NOT NULL,
+  borg_user_id        VARCHAR(36)                                                     NOT NULL,
+  encrypted_token     VARCHAR(4096) DEFAULT ''                                        NOT NULL,
+  created_at          DATETIME DEFAULT CURRENT_TIMESTAMP                              NOT NULL,
+  updated_at          DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP  NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE INDEX oidc_users_issuer_subject (issuer, subject)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+ALTER TABLE oidc_users
+      ADD FOREIGN KEY (borg_user_id) REFERENCES users (id);
@@ -0,0 +1,8 @@
+USE borg;
+
+-- the user directory looks users up by login, name or email prefix
+
+ALTER TABLE users
+      ADD INDEX users_login (login(191)),
+      ADD INDEX users_name (name(191)),
+      ADD INDEX users_email (email(191));
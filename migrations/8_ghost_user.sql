@@ -0,0 +1,7 @@
+USE borg;
+
+-- deleted accounts give their organizations rows and snippets to this user,
+-- it cannot log in as it has no identity and its empty login is never looked up
+
+INSERT IGNORE INTO users (id, login, name, email, avatar_url, account_type)
+       VALUES ('00000000-0000-0000-0000-000000000000', '', 'Deleted user', '', '', 'GHOST');
@@ -0,0 +1,95 @@
+// Command 9_organization_indexes moves the snippets of every organization from the
+// index named after the organization to the one keyed on its id, see domain.Organization.Index.
+// It can be run again, snippets already moved are overwritten with themselves.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	"github.com/ok-borg/api/domain"
+	"github.com/ok-borg/api/endpoints"
+	"gopkg.in/olivere/elastic.v3"
+)
+
+var (
+	esAddr  = flag.String("esaddr", "127.0.0.1:9200", "Elastic Search address")
+	sqlAddr = flag.String("sqladdr", "127.0.0.1:3306", "Mysql address")
+	sqlIds  = flag.String("sqlids", "root:root", "Mysql identifiers")
+	del     = flag.Bool("delete", false, "Delete the old indexes once copied")
+)
+
+func main() {
+	flag.Parse()
+	client, err := elastic.NewClient(elastic.SetSniff(false), elastic.SetURL(fmt.Sprintf("http://%v", *esAddr)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := gorm.Open("mysql", fmt.Sprintf("%s@tcp(%s)/borg?parseTime=True", *sqlIds, *sqlAddr))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	orgs := []domain.Organization{}
+	if err := db.Find(&orgs).Error; err != nil {
+		log.Fatal(err)
+	}
+	for _, o := range orgs {
+		if o.Name == endpoints.PublicBorgSnippet {
+			// its snippets are mixed with the public ones, nothing to tell them apart
+			log.Printf("skipping organization %s named like the public index", o.Id)
+			continue
+		}
+		exists, err := client.IndexExists(o.Name).Do()
+		if err != nil {
+			log.Fatalf("organization %s: %s", o.Id, err)
+		}
+		if !exists {
+			continue
+		}
+		n, err := copyIndex(client, o.Name, o.Index())
+		if err != nil {
+			log.Fatalf("organization %s: %s", o.Id, err)
+		}
+		log.Printf("organization %s: %d snippets copied from %s to %s", o.Id, n, o.Name, o.Index())
+		if *del {
+			if _, err := client.DeleteIndex(o.Name).Do(); err != nil {
+				log.Fatalf("organization %s: %s", o.Id, err)
+			}
+		}
+	}
+}
+
+func copyIndex(client *elastic.Client, from string, to string) (int, error) {
+	copied := 0
+	scroll := client.Scroll(from).Type("problem").Size(100)
+	for {
+		res, err := scroll.Do()
+		if err == io.EOF {
+			return copied, nil
+		}
+		if err != nil {
+			return copied, err
+		}
+		bulk := client.Bulk().Refresh(true)
+		for _, hit := range res.Hits.Hits {
+			bulk.Add(elastic.NewBulkIndexRequest().Index(to).Type("problem").Id(hit.Id).Doc(hit.Source))
+		}
+		if bulk.NumberOfActions() == 0 {
+			continue
+		}
+		bres, err := bulk.Do()
+		if err != nil {
+			return copied, err
+		}
+		if failed := bres.Failed(); len(failed) > 0 {
+			return copied, fmt.Errorf("unable to copy snippet %s: status %d", failed[0].Id, failed[0].Status)
+		}
+		copied += len(res.Hits.Hits)
+	}
+}
@@ -7,3 +7,20 @@ mysql -v --host=$HOST -P $PORT -u root --password=root < 0_create_db.sql
 mysql -v --host=$HOST -P $PORT -u root --password=root < 1_create_users.sql
 mysql -v --host=$HOST -P $PORT -u root --password=root < 2_create_organizations.sql
 mysql -v --host=$HOST -P $PORT -u root --password=root < 3_create_organizations_join_links.sql
+mysql -v --host=$HOST -P $PORT -u root --password=root < 4_hash_access_tokens.sql
+mysql -v --host=$HOST -P $PORT -u root --password=root < 5_personal_access_tokens.sql
+mysql -v --host=$HOST -P $PORT -u root --password=root < 6_identity_providers.sql
+mysql -v --host=$HOST -P $PORT -u root --password=root < 7_users_directory.sql
+mysql -v --host=$HOST -P $PORT -u root --password=root < 8_ghost_user.sql
+# snippets of organizations live in indexes keyed on their id, add -delete to drop the old ones
+go run 9_organization_indexes/main.go -sqladdr $HOST:$PORT -esaddr ${ESADDR:-127.0.0.1:9200}
+mysql -v --host=$HOST -P $PORT -u root --password=root < 10_organization_roles.sql
+mysql -v --host=$HOST -P $PORT -u root --password=root < 11_organization_deletion.sql
+mysql -v --host=$HOST -P $PORT -u root --password=root < 12_organization_join_links.sql
+mysql -v --host=$HOST -P $PORT -u root --password=root < 13_organization_invitations.sql
+mysql -v --host=$HOST -P $PORT -u root --password=root < 14_github_organizations.sql
+mysql -v --host=$HOST -P $PORT -u root --password=root < 15_audit_events.sql
+mysql -v --host=$HOST -P $PORT -u root --password=root < 16_organizations_unique_name.sql
+mysql -v --host=$HOST -P $PORT -u root --password=root < 17_identity_refresh_tokens.sql
+mysql -v --host=$HOST -P $PORT -u root --password=root < 18_oidc_unverified_emails.sql
+mysql -v --host=$HOST -P $PORT -u root --password=root < 19_organizations_reserved_names.sql
@@ -0,0 +1,35 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// FileNotifier writes every message as a json file in a directory,
+// it stands in for a mail server in development and tests
+type FileNotifier struct {
+	dir string
+}
+
+func NewFileNotifier(dir string) *FileNotifier {
+	return &FileNotifier{dir: dir}
+}
+
+func (n *FileNotifier) Notify(ctx context.Context, m Message) error {
+	if err := os.MkdirAll(n.dir, 0700); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.json", time.Now().UnixNano(), uuid.NewV4().String())
+	return ioutil.WriteFile(filepath.Join(n.dir, name), raw, 0600)
+}
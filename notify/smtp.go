@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SmtpNotifier sends the messages through an smtp server
+type SmtpNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSmtpNotifier returns a notifier sending from the from address through the server at addr,
+// authenticating only when username is set
+func NewSmtpNotifier(addr string, from string, username string, password string) *SmtpNotifier {
+	n := &SmtpNotifier{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		n.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return n
+}
+
+func (n *SmtpNotifier) Notify(ctx context.Context, m Message) error {
+	// headers come from the users, never let them add one
+	if strings.ContainsAny(m.To+m.Subject, "\r\n") {
+		return fmt.Errorf("invalid message header")
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n"+
+		"MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		n.from, m.To, m.Subject, time.Now().Format(time.RFC1123Z), m.Body)
+	return smtp.SendMail(n.addr, n.auth, n.from, []string{m.To}, []byte(msg))
+}
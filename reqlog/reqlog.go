@@ -0,0 +1,136 @@
+package reqlog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/ok-borg/api/ctxext"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	HeaderRequestId = "X-Request-ID"
+	// longer ids sent by clients are replaced by a generated one
+	maxRequestIdLen = 128
+)
+
+var jsonFormat bool
+
+// Init selects how the messages are built, must match the format of the seelog logger
+func Init(json bool) {
+	jsonFormat = json
+}
+
+func Debugf(ctx context.Context, format string, params ...interface{}) {
+	log.Debug(message(ctx, fmt.Sprintf(format, params...)))
+}
+
+func Infof(ctx context.Context, format string, params ...interface{}) {
+	log.Info(message(ctx, fmt.Sprintf(format, params...)))
+}
+
+func Warnf(ctx context.Context, format string, params ...interface{}) {
+	log.Warn(message(ctx, fmt.Sprintf(format, params...)))
+}
+
+func Errorf(ctx context.Context, format string, params ...interface{}) {
+	log.Error(message(ctx, fmt.Sprintf(format, params...)))
+}
+
+// in json the message is an object merged into the log line by the seelog formatter,
+// in text the request id just prefixes the message
+func message(ctx context.Context, msg string) string {
+	id, _ := ctxext.RequestId(ctx)
+	if !jsonFormat {
+		if id == "" {
+			return msg
+		}
+		return fmt.Sprintf("[%s] %s", id, msg)
+	}
+	fields := map[string]interface{}{"msg": msg}
+	if id != "" {
+		fields["request_id"] = id
+	}
+	// seelog would report this file, so report the caller of the helper instead
+	if _, file, line, ok := runtime.Caller(2); ok {
+		fields["file"] = fmt.Sprintf("%s:%d", file, line)
+	}
+	return encode(fields)
+}
+
+func encode(fields map[string]interface{}) string {
+	bs, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Sprintf("%v", fields)
+	}
+	return string(bs)
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func validRequestId(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIdLen {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware assigns a request id to every request, or propagates the one sent by the client,
+// stores it in the request context and writes one access log line per request.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(HeaderRequestId)
+		if !validRequestId(id) {
+			id = uuid.NewV4().String()
+		}
+		w.Header().Set(HeaderRequestId, id)
+		r = r.WithContext(ctxext.WithRequestId(r.Context(), id))
+
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		// only the path is logged, the query string may contain the access token
+		duration := time.Since(start)
+		if !jsonFormat {
+			log.Infof("[%s] %s %s %d %dB %v", id, r.Method, r.URL.Path, rw.status, rw.bytes, duration)
+			return
+		}
+		log.Info(encode(map[string]interface{}{
+			"type":        "access",
+			"request_id":  id,
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      rw.status,
+			"bytes":       rw.bytes,
+			"duration_ms": float64(duration) / float64(time.Millisecond),
+			"remote_addr": r.RemoteAddr,
+			"user_agent":  r.UserAgent(),
+		}))
+	})
+}
@@ -0,0 +1,116 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// number of random bytes in an access token
+const tokenSize = 32
+
+var key []byte
+
+// Init sets the key used to encrypt secrets stored in the database,
+// hexKey must be a 32 bytes hex encoded key
+func Init(hexKey string) error {
+	k, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return fmt.Errorf("invalid secret key: %s", err.Error())
+	}
+	if len(k) != 32 {
+		return errors.New("invalid secret key: must be 32 bytes")
+	}
+	key = k
+	return nil
+}
+
+// NewToken returns a new random access token
+func NewToken() (string, error) {
+	b := make([]byte, tokenSize)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hash of a token as stored in database,
+// tokens are random enough to not need a salt
+func HashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// Encrypt seals plain with AES-GCM, the nonce is prepended to the result
+func Encrypt(plain string) (string, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value sealed by Encrypt
+func Decrypt(encrypted string) (string, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// Sign returns a base64 url encoded mac of data
+func Sign(data []byte) (string, error) {
+	if key == nil {
+		return "", errors.New("secret key is not initialized")
+	}
+	// never use the encryption key itself as a mac key
+	k := hmac.New(sha256.New, key)
+	k.Write([]byte("borg-api sign"))
+	mac := hmac.New(sha256.New, k.Sum(nil))
+	mac.Write(data)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Verify tells if sig was returned by Sign for data
+func Verify(data []byte, sig string) bool {
+	expected, err := Sign(data)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(expected), []byte(sig))
+}
+
+func newGCM() (cipher.AEAD, error) {
+	if key == nil {
+		return nil, errors.New("secret key is not initialized")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -16,6 +16,19 @@ type Problem struct {
 	LastUpdated   time.Time  `json:"Updated,omitempty"`
 }
 
+// Snippet is a problem as returned by the api, with its author resolved
+type Snippet struct {
+	Problem
+	Author *Author `json:"Author,omitempty"`
+}
+
+// Author is the public identity of whoever created a snippet
+type Author struct {
+	Id        string `json:"Id"`
+	Login     string `json:"Login"`
+	AvatarUrl string `json:"AvatarUrl"`
+}
+
 // ImportMeta describes where the entry comes from if it comes from anywhere else than borg.
 type ImportMeta struct {
 	Source int    `json:"Source,omitempty"` // enum, 0 stackoverflow
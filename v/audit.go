@@ -0,0 +1,62 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	httpr "github.com/julienschmidt/httprouter"
+	"github.com/ok-borg/api/ctxext"
+	"github.com/ok-borg/api/domain"
+)
+
+// list the audit log of an organization, latest first, only for its admins.
+// ?actor=, ?action=, ?since= and ?until= (RFC 3339) filter the events
+func ListAuditEvents(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	from, size, err := ReadPagination(r, 50)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+	filter := domain.AuditFilter{
+		OrganizationId: p.ByName("id"),
+		ActorId:        r.FormValue("actor"),
+		Action:         r.FormValue("action"),
+	}
+	if filter.Since, err = readTime(r, "since"); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+	if filter.Until, err = readTime(r, "until"); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	events, total, err := ep.ListAuditEvents(db, u.Id, filter, from, size)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+	ret := map[string]interface{}{}
+	ret["events"] = events
+	ret["total"] = total
+	WriteJsonResponse(w, http.StatusOK, ret)
+}
+
+// readTime reads an optional RFC 3339 time from the form
+func readTime(r *http.Request, key string) (*time.Time, error) {
+	v := r.FormValue(key)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, domain.Invalidf("borg-api: invalid %s %s, expected RFC 3339", key, v)
+	}
+	return &t, nil
+}
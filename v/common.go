@@ -3,17 +3,20 @@ package common
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"time"
 
-	log "github.com/cihub/seelog"
 	"github.com/jinzhu/gorm"
 	"github.com/jpillora/go-ogle-analytics"
 	httpr "github.com/julienschmidt/httprouter"
 	"github.com/ok-borg/api/ctxext"
+	"github.com/ok-borg/api/domain"
 	"github.com/ok-borg/api/endpoints"
+	"github.com/ok-borg/api/identity"
+	"github.com/ok-borg/api/reqlog"
 	"gopkg.in/olivere/elastic.v3"
 )
 
@@ -22,7 +25,6 @@ var (
 	analyticsClient *ga.Client
 	ep              *endpoints.Endpoints
 	db              *gorm.DB
-	githubClientId  string
 )
 
 func Init(
@@ -30,13 +32,11 @@ func Init(
 	analyticsClient_ *ga.Client,
 	ep_ *endpoints.Endpoints,
 	db_ *gorm.DB,
-	githubClientId_ string,
 ) {
 	client = client_
 	analyticsClient = analyticsClient_
 	ep = ep_
 	db = db_
-	githubClientId = githubClientId_
 }
 
 func WriteJsonResponse(w http.ResponseWriter, status int, body interface{}) {
@@ -51,57 +51,194 @@ func WriteResponse(w http.ResponseWriter, status int, body string) {
 	fmt.Fprintf(w, `%v`, body)
 }
 
+// Error is the body of every error response, wrapped in an "error" key
+type Error struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestId string      `json:"request_id,omitempty"`
+}
+
+var errorStatuses = map[domain.ErrorKind]struct {
+	status int
+	code   string
+}{
+	domain.ErrInternal:     {http.StatusInternalServerError, "internal"},
+	domain.ErrValidation:   {http.StatusBadRequest, "invalid"},
+	domain.ErrUnauthorized: {http.StatusUnauthorized, "unauthorized"},
+	domain.ErrForbidden:    {http.StatusForbidden, "forbidden"},
+	domain.ErrNotFound:     {http.StatusNotFound, "not_found"},
+	domain.ErrConflict:     {http.StatusConflict, "conflict"},
+	domain.ErrRateLimited:  {http.StatusTooManyRequests, "rate_limited"},
+}
+
+// WriteError writes err in the error envelope with the status matching its kind.
+// Internal errors are logged and their message is not sent to the client.
+func WriteError(ctx context.Context, w http.ResponseWriter, err error) {
+	kind := domain.Kind(err)
+	s := errorStatuses[kind]
+	body := Error{Code: s.code, Message: err.Error()}
+	if e, ok := err.(domain.Error); ok {
+		body.Details = e.Details
+	}
+	if kind == domain.ErrInternal {
+		reqlog.Errorf(ctx, "[WriteError] %s", err.Error())
+		body.Message = "borg-api: internal error"
+	}
+	body.RequestId, _ = ctxext.RequestId(ctx)
+	WriteJsonResponse(w, s.status, map[string]Error{"error": body})
+}
+
+// WriteErrorf is a shortcut for WriteError with a new domain.Error
+func WriteErrorf(ctx context.Context, w http.ResponseWriter, kind domain.ErrorKind, format string, args ...interface{}) {
+	WriteError(ctx, w, domain.NewError(kind, format, args...))
+}
+
 func ReadJsonBody(r *http.Request, expectedBody interface{}) error {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return errors.New("unable to read body")
+		return domain.Invalidf("borg-api: unable to read body")
 	}
 	if err := json.Unmarshal(body, expectedBody); err != nil {
-		log.Errorf(
-			"[ReadJsonBody] invalid request, %s, input was %s",
-			err.Error(), string(body))
-		return errors.New("invalid json body format")
+		// the body is not logged, it may contain secrets
+		reqlog.Errorf(r.Context(), "[ReadJsonBody] invalid request, %s", err.Error())
+		return domain.Error{
+			Kind:    domain.ErrValidation,
+			Message: "borg-api: invalid json body format",
+			Details: err.Error(),
+		}
 	}
 	return nil
 }
 
-// just redirect the user with the url to the github oauth login with the client_id
-// setted in the backend
+// ReadPagination reads the ?from= and ?size= parameters of a listing
+func ReadPagination(r *http.Request, defaultSize int) (int, int, error) {
+	from, size := 0, defaultSize
+	if v := r.FormValue("from"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, 0, domain.Invalidf("borg-api: invalid from %s", v)
+		}
+		from = i
+	}
+	if v := r.FormValue("size"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, 0, domain.Invalidf("borg-api: invalid size %s", v)
+		}
+		size = i
+	}
+	return from, size, nil
+}
+
+// redirect the user to the provider oauth login.
+// A client doing PKCE itself sends its code_challenge (S256), otherwise the
+// verifier is generated here and kept in a cookie for the login call.
+// redirect_uri must be one of the allowed redirect urls.
+func RedirectAuthorize(w http.ResponseWriter, r *http.Request, p httpr.Params) {
+	redirectAuthorize(w, r, p.ByName("provider"))
+}
+
+// the body holds the code and state given back by the provider,
+// and the PKCE code verifier when the client generated the challenge
+func Login(w http.ResponseWriter, r *http.Request, p httpr.Params) {
+	login(w, r, p.ByName("provider"))
+}
+
+// v1 only knows github
 func RedirectGithubAuthorize(w http.ResponseWriter, r *http.Request, p httpr.Params) {
-	url := fmt.Sprintf(
-		"https://github.com/login/oauth/authorize?client_id=%s&scope=read:org",
-		githubClientId,
-	)
-	http.Redirect(w, r, url, http.StatusSeeOther)
+	redirectAuthorize(w, r, identity.Github)
 }
 
 func GithubAuth(w http.ResponseWriter, r *http.Request, p httpr.Params) {
-	body, err := ioutil.ReadAll(r.Body)
+	login(w, r, identity.Github)
+}
+
+// the cookie keeping the PKCE verifier generated by the api
+const pkceCookie = "borg_pkce"
+
+func redirectAuthorize(w http.ResponseWriter, r *http.Request, provider string) {
+	q := r.URL.Query()
+	challenge := q.Get("code_challenge")
+	if challenge != "" && q.Get("code_challenge_method") != "S256" {
+		WriteErrorf(r.Context(), w, domain.ErrValidation, "borg-api: code_challenge_method must be S256")
+		return
+	}
+	var verifier string
+	if challenge == "" {
+		var err error
+		if verifier, err = identity.NewCodeVerifier(); err != nil {
+			WriteError(r.Context(), w, err)
+			return
+		}
+		challenge = identity.CodeChallenge(verifier)
+	}
+	url, err := ep.AuthorizeURL(provider, q.Get("redirect_uri"), challenge)
 	if err != nil {
-		panic(err)
+		WriteError(r.Context(), w, err)
+		return
 	}
-	user, token, err := ep.GithubAuth(string(body))
+	if verifier != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     pkceCookie,
+			Value:    verifier,
+			Path:     "/",
+			MaxAge:   int((10 * time.Minute).Seconds()),
+			HttpOnly: true,
+			Secure:   isHttps(r),
+		})
+	}
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+func login(w http.ResponseWriter, r *http.Request, provider string) {
+	expectedBody := struct {
+		Code         string
+		State        string
+		CodeVerifier string
+	}{}
+	if err := ReadJsonBody(r, &expectedBody); err != nil {
+		WriteError(r.Context(), w, err)
+		return
+	}
+	verifier := codeVerifier(r, expectedBody.CodeVerifier)
+	user, token, err := ep.Login(r.Context(), provider, expectedBody.Code, expectedBody.State, verifier)
 	if err != nil {
-		fmt.Fprintln(w, fmt.Sprintf("Auth failed: %v", err))
+		WriteError(r.Context(), w, err)
 		return
 	}
+	// a verifier is good for one login only
+	http.SetCookie(w, &http.Cookie{
+		Name:     pkceCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHttps(r),
+	})
 	ret := map[string]interface{}{}
 	ret["user"] = user
 	ret["token"] = token
-	bs, err := json.Marshal(ret)
-	if err != nil {
-		panic(err)
+	WriteJsonResponse(w, http.StatusOK, ret)
+}
+
+// codeVerifier returns the verifier sent by the client or else the one in the cookie
+func codeVerifier(r *http.Request, sent string) string {
+	if sent != "" {
+		return sent
+	}
+	if c, err := r.Cookie(pkceCookie); err == nil {
+		return c.Value
 	}
-	fmt.Fprint(w, string(bs))
+	return ""
+}
+
+func isHttps(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
 }
 
 func GetUser(ctx context.Context, w http.ResponseWriter, r *http.Request, p httpr.Params) {
 	user, _ := ctxext.User(ctx)
-	bs, err := json.Marshal(user)
-	if err != nil {
-		panic(err)
-	}
-	fmt.Fprint(w, string(bs))
+	WriteJsonResponse(w, http.StatusOK, user)
 }
 
 func CreateOrganization(
@@ -113,17 +250,82 @@ func CreateOrganization(
 	// first unmarshal body
 	expectedBody := struct{ Name string }{}
 	if err := ReadJsonBody(r, &expectedBody); err != nil {
-		WriteResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("borg-api: %s", err.Error()))
+		WriteError(ctx, w, err)
 		return
 	}
 
 	u, _ := ctxext.User(ctx)
 	// lets create an org
-	o, err := ep.CreateOrganization(db, u.Id, expectedBody.Name)
+	o, err := ep.CreateOrganization(ctx, db, u.Id, expectedBody.Name)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, o)
+}
+
+// an organization with its creator and counts, only for its members
+func GetOrganization(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	u, _ := ctxext.User(ctx)
+	o, err := ep.GetOrganization(db, u.Id, p.ByName("id"))
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, o)
+}
+
+// members of an organization, paginated with ?from= and ?size=
+func ListOrganizationMembers(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	from, size, err := ReadPagination(r, 50)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	members, total, err := ep.ListOrganizationMembers(db, u.Id, p.ByName("id"), from, size)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+	ret := map[string]interface{}{}
+	ret["members"] = members
+	ret["total"] = total
+	WriteJsonResponse(w, http.StatusOK, ret)
+}
+
+// rename an organization, only for its administrators
+func RenameOrganization(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	id := p.ByName("id")
+	if len(id) == 0 {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
+		return
+	}
+	expectedBody := struct{ Name string }{}
+	if err := ReadJsonBody(r, &expectedBody); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	o, err := ep.RenameOrganization(ctx, db, u.Id, id, expectedBody.Name)
 	if err != nil {
-		WriteResponse(w, http.StatusInternalServerError,
-			"borg-api: create organization error: "+err.Error())
+		WriteError(ctx, w, err)
 		return
 	}
 
@@ -141,35 +343,65 @@ func CreateOrganizationJoinLink(
 	// first unmarshal body
 	expectedBody := struct {
 		OrganizationId string
+		Name           string
 		Ttl            int64
+		MaxUses        int64
+		Role           string
 	}{}
 	if err := ReadJsonBody(r, &expectedBody); err != nil {
-		WriteResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("borg-api: %s", err.Error()))
+		WriteError(ctx, w, err)
 		return
 	}
 
-	// check mandatory fields
-	if expectedBody.OrganizationId == "" || expectedBody.Ttl <= 0 {
-		log.Errorf(
+	// check mandatory fields, a link without ttl never expires
+	if expectedBody.OrganizationId == "" || expectedBody.Ttl < 0 {
+		reqlog.Errorf(ctx,
 			"[createOrganizationJoinLink] invalid createOrganizationjoinlink body")
-		WriteResponse(w, http.StatusBadRequest, "borg-api: invalid body")
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: invalid body")
 		return
 	}
 
 	u, _ := ctxext.User(ctx)
 	// ceate the organizartion Join Link
-	o, err := ep.CreateOrganizationJoinLink(db, u.Id, expectedBody.OrganizationId, expectedBody.Ttl)
+	o, err := ep.CreateOrganizationJoinLink(ctx, db, u.Id, expectedBody.OrganizationId,
+		domain.OrganizationJoinLink{
+			Name:    expectedBody.Name,
+			Ttl:     expectedBody.Ttl,
+			MaxUses: expectedBody.MaxUses,
+			Role:    expectedBody.Role,
+		})
 	if err != nil {
-		WriteResponse(w, http.StatusInternalServerError,
-			"borg-api: create organization join link error: "+err.Error())
+		WriteError(ctx, w, err)
 		return
 	}
 
 	WriteJsonResponse(w, http.StatusOK, o)
 }
 
-// delete an existing link
+// list the join links of an organization, revoked ones included
+// only for the organization admins
+func ListOrganizationJoinLinks(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	id := p.ByName("id")
+	if len(id) == 0 {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	links, err := ep.ListOrganizationJoinLinks(db, u.Id, id)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, links)
+}
+
+// revoke an existing link
 // same as previously
 func DeleteOrganizationJoinLink(
 	ctx context.Context,
@@ -178,15 +410,14 @@ func DeleteOrganizationJoinLink(
 	p httpr.Params) {
 	id := p.ByName("id")
 	if len(id) == 0 {
-		WriteResponse(w, http.StatusBadRequest, "borg-api: Missing id url parameter")
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
 		return
 	}
 
 	u, _ := ctxext.User(ctx)
 	// delete the organizartion Join Link
 	if err := ep.DeleteOrganizationJoinLink(db, u.Id, id); err != nil {
-		WriteResponse(w, http.StatusInternalServerError,
-			"borg-api: delete organization join link error: "+err.Error())
+		WriteError(ctx, w, err)
 		return
 	}
 	WriteResponse(w, http.StatusOK, "")
@@ -203,16 +434,15 @@ func GetOrganizationJoinLink(
 	p httpr.Params) {
 	id := p.ByName("id")
 	if len(id) == 0 {
-		WriteResponse(w, http.StatusBadRequest, "borg-api: Missing id url parameter")
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
 		return
 	}
 
-	// get the organization join link
-	// no need of user id or anythin
-	ojl, err := ep.GetOrganizationJoinLink(db, id)
+	// only the admins of the organization get the link itself
+	u, _ := ctxext.User(ctx)
+	ojl, err := ep.GetOrganizationJoinLink(db, u.Id, id)
 	if err != nil {
-		WriteResponse(w, http.StatusInternalServerError,
-			"borg-api: get organization join link error: "+err.Error())
+		WriteError(ctx, w, err)
 		return
 	}
 
@@ -228,22 +458,37 @@ func GetOrganizationJoinLinkByOrganizationId(
 	p httpr.Params) {
 	id := p.ByName("id")
 	if len(id) == 0 {
-		WriteResponse(w, http.StatusBadRequest, "borg-api: Missing id url parameter")
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
 		return
 	}
 
 	u, _ := ctxext.User(ctx)
 	// ceate the organizartion Join Link
-	ojl, err := ep.GetOrganizationJoinLinkForOrganization(db, u.Id, id)
+	ojl, err := ep.GetOrganizationJoinLinkForOrganization(ctx, db, u.Id, id)
 	if err != nil {
-		WriteResponse(w, http.StatusInternalServerError,
-			"borg-api: get organization join link error: "+err.Error())
+		WriteError(ctx, w, err)
 		return
 	}
 
 	WriteJsonResponse(w, http.StatusOK, ojl)
 }
 
+// preview a join link before logging in
+func PreviewOrganizationJoinLink(w http.ResponseWriter, r *http.Request, p httpr.Params) {
+	id := p.ByName("id")
+	if len(id) == 0 {
+		WriteErrorf(r.Context(), w, domain.ErrValidation, "borg-api: Missing id url parameter")
+		return
+	}
+	preview, err := ep.PreviewOrganizationJoinLink(db, id)
+	if err != nil {
+		WriteError(r.Context(), w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, preview)
+}
+
 // join an organization.
 // if join link is not expired.
 func JoinOrganization(
@@ -253,15 +498,14 @@ func JoinOrganization(
 	p httpr.Params) {
 	id := p.ByName("id")
 	if len(id) == 0 {
-		WriteResponse(w, http.StatusBadRequest, "borg-api: Missing id url parameter")
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
 		return
 	}
 
 	u, _ := ctxext.User(ctx)
 	// ceate the organizartion Join Link
 	if err := ep.JoinOrganization(db, u.Id, id); err != nil {
-		WriteResponse(w, http.StatusInternalServerError,
-			"borg-api: cannot join organization: "+err.Error())
+		WriteError(ctx, w, err)
 		return
 	}
 
@@ -276,10 +520,9 @@ func ListUserOrganizations(
 	p httpr.Params) {
 	u, _ := ctxext.User(ctx)
 	// ceate the organizartion Join Link
-	orgz, err := ep.ListUserOrganizations(db, u.Id)
+	orgz, err := ep.ListUserOrganizations(ctx, db, u.Id)
 	if err != nil {
-		WriteResponse(w, http.StatusInternalServerError,
-			"borg-api: list user organizations error: "+err.Error())
+		WriteError(ctx, w, err)
 		return
 	}
 
@@ -296,15 +539,14 @@ func LeaveOrganization(
 ) {
 	organizationId := p.ByName("id")
 	if len(organizationId) == 0 {
-		WriteResponse(w, http.StatusBadRequest, "borg-api: Missing id url parameter")
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
 		return
 	}
 
 	u, _ := ctxext.User(ctx)
 	// ceate the organizartion Join Link
 	if err := ep.LeaveOrganization(db, u.Id, organizationId); err != nil {
-		WriteResponse(w, http.StatusInternalServerError,
-			"borg-api: cannot leave organization: "+err.Error())
+		WriteError(ctx, w, err)
 		return
 	}
 
@@ -322,20 +564,19 @@ func ExpelUserFromOrganization(
 ) {
 	organizationId := p.ByName("oid")
 	if len(organizationId) == 0 {
-		WriteResponse(w, http.StatusBadRequest, "borg-api: Missing organizationId url parameter")
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing organizationId url parameter")
 		return
 	}
 	userId := p.ByName("uid")
 	if len(userId) == 0 {
-		WriteResponse(w, http.StatusBadRequest, "borg-api: Missing userId url parameter")
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing userId url parameter")
 		return
 	}
 
 	u, _ := ctxext.User(ctx)
 	// ceate the organizartion Join Link
 	if err := ep.ExpelUserFromOrganization(db, u.Id, userId, organizationId); err != nil {
-		WriteResponse(w, http.StatusInternalServerError,
-			"borg-api: cannot expel from organization: "+err.Error())
+		WriteError(ctx, w, err)
 		return
 	}
 
@@ -350,34 +591,154 @@ func GrantAdminRightToUser(
 ) {
 	organizationId := p.ByName("oid")
 	if len(organizationId) == 0 {
-		WriteResponse(w, http.StatusBadRequest, "borg-api: Missing organizationId url parameter")
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing organizationId url parameter")
 		return
 	}
 	userId := p.ByName("uid")
 	if len(userId) == 0 {
-		WriteResponse(w, http.StatusBadRequest, "borg-api: Missing userId url parameter")
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing userId url parameter")
 		return
 	}
 
 	u, _ := ctxext.User(ctx)
 	// ceate the organizartion Join Link
 	if err := ep.GrantAdminRightToUser(db, u.Id, userId, organizationId); err != nil {
-		WriteResponse(w, http.StatusInternalServerError,
-			"borg-api: cannot expel from organization: "+err.Error())
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusNoContent, "")
+}
+
+// change the role of a member of an organization
+// admins change the roles of the members below them
+func ChangeMemberRole(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params,
+) {
+	organizationId := p.ByName("id")
+	if len(organizationId) == 0 {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing organizationId url parameter")
+		return
+	}
+	userId := p.ByName("uid")
+	if len(userId) == 0 {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing userId url parameter")
+		return
+	}
+	expectedBody := struct{ Role string }{}
+	if err := ReadJsonBody(r, &expectedBody); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	uo, err := ep.ChangeMemberRole(db, u.Id, userId, organizationId, expectedBody.Role)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, uo)
+}
+
+// make another member the owner of the organization
+// only the owner can do it, and stays an admin
+func TransferOrganization(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	id := p.ByName("id")
+	if len(id) == 0 {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
+		return
+	}
+	expectedBody := struct{ UserId string }{}
+	if err := ReadJsonBody(r, &expectedBody); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+	if expectedBody.UserId == "" {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing UserId")
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	if err := ep.TransferOrganization(ctx, db, u.Id, id, expectedBody.UserId); err != nil {
+		WriteError(ctx, w, err)
 		return
 	}
 
 	WriteJsonResponse(w, http.StatusNoContent, "")
 }
 
+// delete an organization, in two steps:
+// without ?confirm= the owner gets a confirmation token,
+// with it the organization is deleted after the grace period
+func DeleteOrganization(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	id := p.ByName("id")
+	if len(id) == 0 {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	confirm := r.URL.Query().Get("confirm")
+	if confirm == "" {
+		d, err := ep.RequestOrganizationDeletion(db, u.Id, id)
+		if err != nil {
+			WriteError(ctx, w, err)
+			return
+		}
+		WriteJsonResponse(w, http.StatusAccepted, d)
+		return
+	}
+	o, err := ep.DeleteOrganization(ctx, db, u.Id, id, confirm)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, o)
+}
+
+// cancel the deletion of an organization during its grace period
+func RestoreOrganization(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	id := p.ByName("id")
+	if len(id) == 0 {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	o, err := ep.RestoreOrganization(ctx, db, u.Id, id)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, o)
+}
+
 func SlackCommand(w http.ResponseWriter, r *http.Request, p httpr.Params) {
 	if err := r.ParseForm(); err != nil {
-		WriteResponse(w, http.StatusInternalServerError, "Something wrong happened, please try again later.")
+		WriteErrorf(r.Context(), w, domain.ErrValidation, "Something wrong happened, please try again later.")
 		return
 	}
-	res, err := ep.Slack(r.FormValue("text"))
+	res, err := ep.Slack(r.Context(), r.FormValue("text"))
 	if err != nil {
-		WriteResponse(w, http.StatusInternalServerError, "Something wrong happened, please try again later.")
+		WriteError(r.Context(), w, err)
 		return
 	}
 
@@ -0,0 +1,62 @@
+package common
+
+import (
+	"context"
+	"net/http"
+
+	httpr "github.com/julienschmidt/httprouter"
+	"github.com/ok-borg/api/ctxext"
+	"github.com/ok-borg/api/domain"
+)
+
+// link an organization to a github organization, or one of its teams,
+// its members become members of the organization
+func LinkGithubOrganization(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	id := p.ByName("id")
+	if len(id) == 0 {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
+		return
+	}
+	expectedBody := struct {
+		Organization string
+		Team         string
+	}{}
+	if err := ReadJsonBody(r, &expectedBody); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	o, err := ep.LinkGithubOrganization(ctx, db, u.Id, id, expectedBody.Organization, expectedBody.Team)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, o)
+}
+
+func UnlinkGithubOrganization(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	id := p.ByName("id")
+	if len(id) == 0 {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	o, err := ep.UnlinkGithubOrganization(ctx, db, u.Id, id)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, o)
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"context"
+	"net/http"
+
+	httpr "github.com/julienschmidt/httprouter"
+	"github.com/ok-borg/api/ctxext"
+	"github.com/ok-borg/api/domain"
+)
+
+// list the identities the user can log in with
+func ListIdentities(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	u, _ := ctxext.User(ctx)
+	links, err := ep.ListIdentities(db, u.Id)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, links)
+}
+
+// link the identity behind an authorization code to the user, the code and state
+// come from a login started with /v2/redirect/:provider/authorize.
+// With Merge the account already owning this identity is merged in the user one
+func LinkIdentity(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	expectedBody := struct {
+		Code         string
+		State        string
+		CodeVerifier string
+		Merge        bool
+	}{}
+	if err := ReadJsonBody(r, &expectedBody); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	verifier := codeVerifier(r, expectedBody.CodeVerifier)
+
+	u, _ := ctxext.User(ctx)
+	link, err := ep.LinkIdentity(ctx, db, u.Id, p.ByName("provider"),
+		expectedBody.Code, expectedBody.State, verifier, expectedBody.Merge)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, link)
+}
+
+func UnlinkIdentity(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	id := p.ByName("id")
+	if len(id) == 0 {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	if err := ep.UnlinkIdentity(ctx, db, u.Id, p.ByName("provider"), id); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteResponse(w, http.StatusOK, "")
+}
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"context"
+	"net/http"
+
+	httpr "github.com/julienschmidt/httprouter"
+	"github.com/ok-borg/api/ctxext"
+	"github.com/ok-borg/api/domain"
+)
+
+// invite a github login or an email address to an organization
+// only for the organization admins
+func InviteToOrganization(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	id := p.ByName("id")
+	if len(id) == 0 {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
+		return
+	}
+	expectedBody := struct {
+		Login string
+		Email string
+		Role  string
+	}{}
+	if err := ReadJsonBody(r, &expectedBody); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	i, err := ep.InviteToOrganization(ctx, db, u.Id, id,
+		expectedBody.Login, expectedBody.Email, expectedBody.Role)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, i)
+}
+
+// list the pending invitations of the user
+func ListInvitations(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	u, _ := ctxext.User(ctx)
+	is, err := ep.ListInvitations(db, u.Id)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, is)
+}
+
+func AcceptInvitation(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	id := p.ByName("id")
+	if len(id) == 0 {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	uo, err := ep.AcceptInvitation(ctx, db, u.Id, id)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, uo)
+}
+
+func DeclineInvitation(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	id := p.ByName("id")
+	if len(id) == 0 {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	if err := ep.DeclineInvitation(ctx, db, u.Id, id); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusNoContent, "")
+}
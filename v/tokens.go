@@ -0,0 +1,130 @@
+package common
+
+import (
+	"context"
+	"net/http"
+
+	httpr "github.com/julienschmidt/httprouter"
+	"github.com/ok-borg/api/ctxext"
+	"github.com/ok-borg/api/domain"
+)
+
+// create a personal access token, the clear token is only returned here
+func CreatePersonalAccessToken(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	expectedBody := struct {
+		Name    string
+		Scopes  domain.Scopes
+		TtlDays int
+	}{}
+	if err := ReadJsonBody(r, &expectedBody); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	at, _ := ctxext.AccessToken(ctx)
+	t, err := ep.CreatePersonalAccessToken(
+		ctx, db, at, expectedBody.Name, expectedBody.Scopes, expectedBody.TtlDays)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, t)
+}
+
+// list the personal access tokens of the user with their last use
+func ListPersonalAccessTokens(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	u, _ := ctxext.User(ctx)
+	tokens, err := ep.ListPersonalAccessTokens(db, u.Id)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, tokens)
+}
+
+func RenamePersonalAccessToken(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	id := p.ByName("id")
+	if len(id) == 0 {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
+		return
+	}
+	expectedBody := struct{ Name string }{}
+	if err := ReadJsonBody(r, &expectedBody); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	t, err := ep.RenamePersonalAccessToken(db, u.Id, id, expectedBody.Name)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, t)
+}
+
+// revoke one of the user tokens, the current one included
+func RevokeAccessToken(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	id := p.ByName("id")
+	if len(id) == 0 {
+		WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
+		return
+	}
+
+	u, _ := ctxext.User(ctx)
+	if err := ep.RevokeAccessToken(ctx, db, u.Id, id); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusNoContent, "")
+}
+
+// revoke the token used for this request
+func Logout(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	token, _ := ctxext.TokenString(ctx)
+	if err := ep.Logout(ctx, db, token); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusNoContent, "")
+}
+
+// revoke every token of the user
+func LogoutEverywhere(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	u, _ := ctxext.User(ctx)
+	if err := ep.LogoutEverywhere(ctx, db, u.Id); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusNoContent, "")
+}
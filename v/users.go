@@ -0,0 +1,112 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+
+	httpr "github.com/julienschmidt/httprouter"
+	"github.com/ok-borg/api/ctxext"
+	"github.com/ok-borg/api/domain"
+)
+
+// public profile of a user, the email is only shown to its co-members
+func GetUserProfile(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	viewer, _ := ctxext.User(ctx)
+	profile, err := ep.GetUserProfile(db, viewer.Id, p.ByName("login"))
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, profile)
+}
+
+// snippets created by a user, paginated with ?from= and ?size=
+func GetUserSnippets(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	from, size, err := ReadPagination(r, 20)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	viewer, _ := ctxext.User(ctx)
+	snippets, err := ep.GetUserSnippets(db, viewer.Id, p.ByName("login"), from, size)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, snippets)
+}
+
+// search users by login, name or email prefix with ?q=
+func SearchUsers(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	viewer, _ := ctxext.User(ctx)
+	profiles, err := ep.SearchUsers(db, viewer.Id, r.URL.Query().Get("q"))
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteJsonResponse(w, http.StatusOK, profiles)
+}
+
+// zip archive of everything borg knows about the user
+func ExportUser(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	u, _ := ctxext.User(ctx)
+	x, err := ep.ExportUser(db, u.Id)
+	if err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+	// built in memory so a failure is still reported as json
+	buf := bytes.Buffer{}
+	if err := x.WriteArchive(&buf); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="borg-export-%s.zip"`, u.Id))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// delete the account of the user, ?confirm= must be its login
+func DeleteUser(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	p httpr.Params) {
+	// a leaked personal token must not be enough to delete the account
+	if at, _ := ctxext.AccessToken(ctx); at.IsPersonal == 1 {
+		WriteErrorf(ctx, w, domain.ErrForbidden, "borg-api: log in to delete your account")
+		return
+	}
+	u, _ := ctxext.User(ctx)
+	if err := ep.DeleteUser(ctx, db, u.Id, r.URL.Query().Get("confirm")); err != nil {
+		WriteError(ctx, w, err)
+		return
+	}
+
+	WriteResponse(w, http.StatusOK, "")
+}
@@ -8,9 +8,9 @@ import (
 	"net/http"
 	"strconv"
 
-	log "github.com/cihub/seelog"
 	httpr "github.com/julienschmidt/httprouter"
 	"github.com/ok-borg/api/endpoints"
+	"github.com/ok-borg/api/reqlog"
 	"github.com/ok-borg/api/types"
 	"github.com/ok-borg/api/v"
 )
@@ -21,9 +21,10 @@ func q(w http.ResponseWriter, r *http.Request, p httpr.Params) {
 	if err == nil && s > 0 {
 		size = int(s)
 	}
-	res, err := ep.Query(r.FormValue("q"), size, r.FormValue("p") == "true")
+	res, err := ep.Query(r.Context(), r.FormValue("q"), size, r.FormValue("p") == "true")
 	if err != nil {
 		common.WriteResponse(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 	bs, err := json.Marshal(res)
 	if err != nil {
@@ -36,6 +37,7 @@ func getLatestSnippets(w http.ResponseWriter, r *http.Request, p httpr.Params) {
 	res, err := ep.GetLatestSnippets(endpoints.PublicBorgSnippet)
 	if err != nil {
 		common.WriteResponse(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 	bs, err := json.Marshal(res)
 	if err != nil {
@@ -52,11 +54,11 @@ func createSnippet(ctx context.Context, w http.ResponseWriter, r *http.Request,
 	}
 	var snipp types.Problem
 	if err := json.Unmarshal(body, &snipp); err != nil {
-		log.Errorf("Invalid snippet, %s, input was %s", err.Error(), string(body))
+		reqlog.Errorf(ctx, "Invalid snippet, %s", err.Error())
 		common.WriteResponse(w, http.StatusBadRequest, "borg-api: Invalid snippet")
 		return
 	}
-	err = ep.CreateSnippet(&snipp, endpoints.PublicBorgSnippet, ctx.Value("userId").(string))
+	err = ep.CreateSnippet(ctx, &snipp, endpoints.PublicBorgSnippet, ctx.Value("userId").(string))
 	if err != nil {
 		common.WriteResponse(w, http.StatusInternalServerError, "borg-api: unable to unmarshal snippet")
 		return
@@ -72,11 +74,11 @@ func updateSnippet(ctx context.Context, w http.ResponseWriter, r *http.Request,
 	}
 	var snipp types.Problem
 	if err := json.Unmarshal(body, &snipp); err != nil {
-		log.Errorf("[updateSnippet] invalid snippet, %s, input was %s", err.Error(), string(body))
+		reqlog.Errorf(ctx, "[updateSnippet] invalid snippet, %s", err.Error())
 		common.WriteResponse(w, http.StatusBadRequest, "borg-api: Invalid snippet")
 		return
 	}
-	err = ep.UpdateSnippet(&snipp, endpoints.PublicBorgSnippet, ctx.Value("userId").(string))
+	err = ep.UpdateSnippet(ctx, &snipp, endpoints.PublicBorgSnippet, ctx.Value("userId").(string))
 	if err != nil {
 		common.WriteResponse(w, http.StatusInternalServerError, "borg-api: error")
 		return
@@ -95,7 +97,7 @@ func snippetWorked(ctx context.Context, w http.ResponseWriter, r *http.Request,
 		Id    string
 	}{}
 	if err := json.Unmarshal(body, &s); err != nil {
-		log.Errorf("[updateSnippet] invalid worked request, %s, input was %s", err.Error(), string(body))
+		reqlog.Errorf(ctx, "[updateSnippet] invalid worked request, %s", err.Error())
 		common.WriteResponse(w, http.StatusBadRequest, "borg-api: Invalid worked request")
 		return
 	}
@@ -3,9 +3,10 @@ package v1
 import (
 	"github.com/jinzhu/gorm"
 	"github.com/jpillora/go-ogle-analytics"
-	httpr "github.com/julienschmidt/httprouter"
 	"github.com/ok-borg/api/access"
+	"github.com/ok-borg/api/domain"
 	"github.com/ok-borg/api/endpoints"
+	"github.com/ok-borg/api/metrics"
 	"github.com/ok-borg/api/v"
 	"gopkg.in/olivere/elastic.v3"
 )
@@ -18,7 +19,7 @@ var (
 )
 
 func Init(
-	r *httpr.Router,
+	r *metrics.Router,
 	client_ *elastic.Client,
 	analyticsClient_ *ga.Client,
 	ep_ *endpoints.Endpoints,
@@ -35,41 +36,49 @@ func Init(
 	r.GET("/v1/query", q)
 
 	// authenticated endpoints
-	r.GET("/v1/user", access.IfAuth(db, common.GetUser))
+	r.GET("/v1/user", access.IfAuth(db, access.RequireScope(domain.ScopeRead, common.GetUser)))
 
 	// snippets
 	r.GET("/v1/p/:id", getSnippet)
 	r.GET("/v1/latest", getLatestSnippets)
-	r.POST("/v1/p", access.IfAuth(db, access.Control(createSnippet, access.Create)))
+	r.POST("/v1/p",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, access.Control(createSnippet, access.Create))))
 	//r.DELETE("/v1/p/:id", access.IfAuth(deleteSnippet))
-	r.PUT("/v1/p", access.IfAuth(db, access.Control(updateSnippet, access.Update)))
-	r.POST("/v1/worked", access.IfAuth(db, snippetWorked))
+	r.PUT("/v1/p",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, access.Control(updateSnippet, access.Update))))
+	r.POST("/v1/worked", access.IfAuth(db, access.RequireScope(domain.ScopeWrite, snippetWorked)))
 	r.POST("/v1/slack", common.SlackCommand)
 
 	// organizations
-	r.POST("/v1/organizations", access.IfAuth(db, common.CreateOrganization))
-	r.GET("/v1/organizations", access.IfAuth(db, common.ListUserOrganizations))
+	r.POST("/v1/organizations",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, common.CreateOrganization)))
+	r.GET("/v1/organizations",
+		access.IfAuth(db, access.RequireScope(domain.ScopeRead, common.ListUserOrganizations)))
 
 	// not rest at all but who cares ?
-	r.POST("/v1/organizations/leave/:id", access.IfAuth(db, common.LeaveOrganization))
+	r.POST("/v1/organizations/leave/:id",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, common.LeaveOrganization)))
 	r.POST("/v1/organizations/expel/:oid/user/id/:uid",
-		access.IfAuth(db, common.ExpelUserFromOrganization))
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.ExpelUserFromOrganization)))
 	r.POST("/v1/organizations/admins/:oid/user/id/:uid",
-		access.IfAuth(db, common.GrantAdminRightToUser))
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.GrantAdminRightToUser)))
 
 	// organizations-join-links
 	// this is only allowed for the organization admin
-	r.POST("/v1/organization-join-links", access.IfAuth(db, common.CreateOrganizationJoinLink))
+	r.POST("/v1/organization-join-links",
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.CreateOrganizationJoinLink)))
 	r.DELETE("/v1/organization-join-links/id/:id",
-		access.IfAuth(db, common.DeleteOrganizationJoinLink))
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.DeleteOrganizationJoinLink)))
 	// get a join link for a specific organization
 	// this is allowed only by the organization admin in order to share it again, or delete it.
 	r.GET("/v1/organization-join-links/organizations/:id",
-		access.IfAuth(db, common.GetOrganizationJoinLinkByOrganizationId))
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.GetOrganizationJoinLinkByOrganizationId)))
 	// get a join link from a join-link id.
+	// only the organization admins get it, like in v2, the others preview it with GET /v2/join/:id
 	r.GET("/v1/organization-join-links/id/:id",
-		access.IfAuth(db, common.GetOrganizationJoinLink))
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.GetOrganizationJoinLink)))
 	// accept join link
 	// not restful at all, but pretty to read
-	r.POST("/v1/join/:id", access.IfAuth(db, common.JoinOrganization))
+	r.POST("/v1/join/:id",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, common.JoinOrganization)))
 }
@@ -8,76 +8,103 @@ import (
 	"net/http"
 	"strconv"
 
-	log "github.com/cihub/seelog"
 	httpr "github.com/julienschmidt/httprouter"
 	"github.com/ok-borg/api/ctxext"
 	"github.com/ok-borg/api/domain"
 	"github.com/ok-borg/api/endpoints"
+	"github.com/ok-borg/api/reqlog"
 	"github.com/ok-borg/api/types"
 	"github.com/ok-borg/api/v"
 )
 
-func matchOrganizationForUser(rawOwner string, userId string) (string, error) {
+func matchOrganizationForUser(rawOwner string, userId string) (domain.Organization, error) {
 	userOrganizationDao := domain.NewUserOrganizationDao(db)
 	orgz, err := userOrganizationDao.ListOrganizationsForUser(userId)
 	if err != nil {
-		return "", fmt.Errorf("database error: %s", err.Error())
+		return domain.Organization{}, fmt.Errorf("database error: %s", err.Error())
 	}
 	if len(orgz) == 0 {
-		return "", fmt.Errorf("user is part of no organizations")
+		return domain.Organization{}, domain.NotFoundf("user is part of no organizations")
 	}
 	organizationDao := domain.NewOrganizationDao(db)
 	matches, err := organizationDao.MatchesInIds(orgz, rawOwner)
 	if err != nil {
-		return "", fmt.Errorf("database error: %s", err.Error())
+		return domain.Organization{}, fmt.Errorf("database error: %s", err.Error())
 	}
 	if len(matches) == 0 {
-		return "", fmt.Errorf("no organizations match the pattern: %s", rawOwner)
+		return domain.Organization{}, domain.NotFoundf("no organizations match the pattern: %s", rawOwner)
 	}
 	if len(matches) > 1 {
-		return "", fmt.Errorf("pattern %s matches multiples organizations", rawOwner)
+		return domain.Organization{}, domain.Invalidf("pattern %s matches multiples organizations", rawOwner)
 	}
-	return matches[0].Name, nil
+	return matches[0], nil
 }
 
+// getRealOwner resolves the owner given by a client to an index,
+// the public index is either no owner or "borg"
 func getRealOwner(rawOwner string, userId string) (string, error) {
 	var index string
 	if rawOwner == "me" {
 		// this will be user specific content
 		index = userId
-	} else if rawOwner == "" {
+	} else if isPublicOwner(rawOwner) {
 		// borg is the global index
 		index = endpoints.PublicBorgSnippet
 	} else {
 		// here we consider this is organizastion specific stuff
 		// let's try to match one user org with this string
-		return matchOrganizationForUser(rawOwner, userId)
+		o, err := matchOrganizationForUser(rawOwner, userId)
+		if err != nil {
+			return "", err
+		}
+		index = o.Index()
 	}
 	return index, nil
 }
 
+// getWritableOwner is getRealOwner for writes,
+// viewers of an organization cannot change its snippets
+func getWritableOwner(rawOwner string, userId string) (string, error) {
+	if rawOwner == "me" || isPublicOwner(rawOwner) {
+		return getRealOwner(rawOwner, userId)
+	}
+	o, err := matchOrganizationForUser(rawOwner, userId)
+	if err != nil {
+		return "", err
+	}
+	uo, err := domain.NewUserOrganizationDao(db).GetByUserAndOrganization(userId, o.Id)
+	if err != nil {
+		return "", fmt.Errorf("database error: %s", err.Error())
+	}
+	if !uo.CanWrite() {
+		return "", domain.Forbiddenf("a %s cannot write the snippets of organization %s", uo.Role, o.Name)
+	}
+	return o.Index(), nil
+}
+
+func isPublicOwner(rawOwner string) bool {
+	return rawOwner == "" || rawOwner == endpoints.PublicBorgSnippet
+}
+
 func q(w http.ResponseWriter, r *http.Request, p httpr.Params) {
 	size := 5
 	s, err := strconv.ParseInt(r.FormValue("l"), 10, 32)
 	if err == nil && s > 0 {
 		size = int(s)
 	}
-	res, err := ep.Query(r.FormValue("q"), size, r.FormValue("p") == "true")
-	if err != nil {
-		common.WriteResponse(w, http.StatusInternalServerError, err.Error())
-	}
-	bs, err := json.Marshal(res)
+	res, err := ep.Query(r.Context(), r.FormValue("q"), size, r.FormValue("p") == "true")
 	if err != nil {
-		panic(err)
+		common.WriteError(r.Context(), w, err)
+		return
 	}
-	fmt.Fprint(w, string(bs))
+	common.WriteJsonResponse(w, http.StatusOK, res)
 }
 
 func getLatestSnippets(
 	ctx context.Context, w http.ResponseWriter, r *http.Request, p httpr.Params) {
 	owner := p.ByName("owner")
 	if len(owner) == 0 {
-		common.WriteResponse(w, http.StatusBadRequest, "borg-api: Missing owner url parameter")
+		common.WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing owner url parameter")
 		return
 	}
 
@@ -89,7 +116,7 @@ func getLatestSnippets(
 		userId, _ := ctxext.UserId(ctx)
 		index, err = getRealOwner(owner, userId)
 		if err != nil {
-			common.WriteResponse(w, http.StatusBadRequest, fmt.Sprintf("borg-api: %s", err.Error()))
+			common.WriteError(ctx, w, err)
 			return
 		}
 	} else {
@@ -99,19 +126,21 @@ func getLatestSnippets(
 
 	res, err := ep.GetLatestSnippets(index)
 	if err != nil {
-		common.WriteResponse(w, http.StatusInternalServerError, err.Error())
+		common.WriteError(ctx, w, err)
+		return
 	}
-	bs, err := json.Marshal(res)
+	snippets, err := ep.WithAuthors(db, res)
 	if err != nil {
-		panic(err)
+		common.WriteError(ctx, w, err)
+		return
 	}
-	common.WriteResponse(w, http.StatusOK, string(bs))
+	common.WriteJsonResponse(w, http.StatusOK, snippets)
 }
 
 func createSnippet(ctx context.Context, w http.ResponseWriter, r *http.Request, p httpr.Params) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		common.WriteResponse(w, http.StatusInternalServerError, "borg-api: unable to read body")
+		common.WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: unable to read body")
 		return
 	}
 	s := struct {
@@ -120,20 +149,20 @@ func createSnippet(ctx context.Context, w http.ResponseWriter, r *http.Request,
 	}{}
 
 	if err := json.Unmarshal(body, &s); err != nil {
-		log.Errorf("[createSnippet] Invalid snippet, %s, input was %s", err.Error(), string(body))
-		common.WriteResponse(w, http.StatusBadRequest, "borg-api: Invalid snippet")
+		reqlog.Errorf(ctx, "[createSnippet] Invalid snippet, %s", err.Error())
+		common.WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Invalid snippet")
 		return
 	}
 
 	userId, _ := ctxext.UserId(ctx)
-	index, err := getRealOwner(s.Owner, userId)
+	index, err := getWritableOwner(s.Owner, userId)
 	if err != nil {
-		common.WriteResponse(w, http.StatusBadRequest, fmt.Sprintf("borg-api: %s", err.Error()))
+		common.WriteError(ctx, w, err)
 		return
 	}
-	err = ep.CreateSnippet(&s.Snippet, index, userId)
+	err = ep.CreateSnippet(ctx, &s.Snippet, index, userId)
 	if err != nil {
-		common.WriteResponse(w, http.StatusInternalServerError, "borg-api: unable to unmarshal snippet")
+		common.WriteError(ctx, w, err)
 		return
 	}
 	common.WriteJsonResponse(w, http.StatusOK, s.Snippet)
@@ -142,7 +171,7 @@ func createSnippet(ctx context.Context, w http.ResponseWriter, r *http.Request,
 func updateSnippet(ctx context.Context, w http.ResponseWriter, r *http.Request, p httpr.Params) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		common.WriteResponse(w, http.StatusInternalServerError, "borg-api: unable to read body")
+		common.WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: unable to read body")
 		return
 	}
 
@@ -152,21 +181,46 @@ func updateSnippet(ctx context.Context, w http.ResponseWriter, r *http.Request,
 	}{}
 
 	if err := json.Unmarshal(body, &s); err != nil {
-		log.Errorf("[updateSnippet] Invalid snippet, %s, input was %s", err.Error(), string(body))
-		common.WriteResponse(w, http.StatusBadRequest, "borg-api: Invalid snippet")
+		reqlog.Errorf(ctx, "[updateSnippet] Invalid snippet, %s", err.Error())
+		common.WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Invalid snippet")
 		return
 	}
 
 	userId, _ := ctxext.UserId(ctx)
-	index, err := getRealOwner(s.Owner, userId)
+	index, err := getWritableOwner(s.Owner, userId)
 	if err != nil {
-		common.WriteResponse(w, http.StatusBadRequest, fmt.Sprintf("borg-api: %s", err.Error()))
+		common.WriteError(ctx, w, err)
 		return
 	}
 
-	err = ep.UpdateSnippet(&s.Snippet, index, ctx.Value("userId").(string))
+	err = ep.UpdateSnippet(ctx, &s.Snippet, index, userId)
 	if err != nil {
-		common.WriteResponse(w, http.StatusInternalServerError, "borg-api: error")
+		common.WriteError(ctx, w, err)
+		return
+	}
+	common.WriteResponse(w, http.StatusOK, "{}")
+}
+
+func deleteSnippet(ctx context.Context, w http.ResponseWriter, r *http.Request, p httpr.Params) {
+	id := p.ByName("id")
+	if len(id) == 0 {
+		common.WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
+		return
+	}
+	owner := p.ByName("owner")
+	if len(owner) == 0 {
+		common.WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing owner url parameter")
+		return
+	}
+
+	userId, _ := ctxext.UserId(ctx)
+	index, err := getWritableOwner(owner, userId)
+	if err != nil {
+		common.WriteError(ctx, w, err)
+		return
+	}
+	if err := ep.DeleteSnippet(ctx, index, id, userId); err != nil {
+		common.WriteError(ctx, w, err)
 		return
 	}
 	common.WriteResponse(w, http.StatusOK, "{}")
@@ -175,7 +229,7 @@ func updateSnippet(ctx context.Context, w http.ResponseWriter, r *http.Request,
 func snippetWorked(ctx context.Context, w http.ResponseWriter, r *http.Request, p httpr.Params) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		common.WriteResponse(w, http.StatusInternalServerError, "borg-api: unable to read body")
+		common.WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: unable to read body")
 		return
 	}
 	s := struct {
@@ -183,13 +237,13 @@ func snippetWorked(ctx context.Context, w http.ResponseWriter, r *http.Request,
 		Id    string
 	}{}
 	if err := json.Unmarshal(body, &s); err != nil {
-		log.Errorf("[updateSnippet] invalid worked request, %s, input was %s", err.Error(), string(body))
-		common.WriteResponse(w, http.StatusBadRequest, "borg-api: Invalid worked request")
+		reqlog.Errorf(ctx, "[updateSnippet] invalid worked request, %s", err.Error())
+		common.WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Invalid worked request")
 		return
 	}
 	err = ep.Worked(s.Id, s.Query)
 	if err != nil {
-		common.WriteResponse(w, http.StatusInternalServerError, "borg-api: error: "+err.Error())
+		common.WriteError(ctx, w, err)
 		return
 	}
 	common.WriteResponse(w, http.StatusOK, "{}")
@@ -199,12 +253,12 @@ func getSnippet(
 	ctx context.Context, w http.ResponseWriter, r *http.Request, p httpr.Params) {
 	id := p.ByName("id")
 	if len(id) == 0 {
-		common.WriteResponse(w, http.StatusBadRequest, "borg-api: Missing id url parameter")
+		common.WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing id url parameter")
 		return
 	}
 	owner := p.ByName("owner")
 	if len(owner) == 0 {
-		common.WriteResponse(w, http.StatusBadRequest, "borg-api: Missing owner url parameter")
+		common.WriteErrorf(ctx, w, domain.ErrValidation, "borg-api: Missing owner url parameter")
 		return
 	}
 
@@ -216,7 +270,7 @@ func getSnippet(
 		userId, _ := ctxext.UserId(ctx)
 		index, err = getRealOwner(owner, userId)
 		if err != nil {
-			common.WriteResponse(w, http.StatusBadRequest, fmt.Sprintf("borg-api: %s", err.Error()))
+			common.WriteError(ctx, w, err)
 			return
 		}
 	} else {
@@ -226,13 +280,17 @@ func getSnippet(
 
 	snipp, err := ep.GetSnippet(index, id)
 	if err != nil {
-		common.WriteResponse(w, http.StatusInternalServerError, "borg-api: Failed to get snippet")
+		common.WriteError(ctx, w, err)
 		return
 	}
 	if snipp == nil {
-		common.WriteResponse(w, http.StatusNotFound, "borg-api: snippet not found")
+		common.WriteErrorf(ctx, w, domain.ErrNotFound, "borg-api: snippet not found")
+		return
+	}
+	snippets, err := ep.WithAuthors(db, []types.Problem{*snipp})
+	if err != nil {
+		common.WriteError(ctx, w, err)
 		return
 	}
-	bs, _ := json.Marshal(snipp)
-	common.WriteResponse(w, http.StatusOK, string(bs))
+	common.WriteJsonResponse(w, http.StatusOK, snippets[0])
 }
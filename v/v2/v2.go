@@ -1,11 +1,15 @@
 package v2
 
 import (
+	"net/http"
+
 	"github.com/jinzhu/gorm"
 	"github.com/jpillora/go-ogle-analytics"
 	httpr "github.com/julienschmidt/httprouter"
 	"github.com/ok-borg/api/access"
+	"github.com/ok-borg/api/domain"
 	"github.com/ok-borg/api/endpoints"
+	"github.com/ok-borg/api/metrics"
 	"github.com/ok-borg/api/v"
 	"gopkg.in/olivere/elastic.v3"
 )
@@ -18,7 +22,7 @@ var (
 )
 
 func Init(
-	r *httpr.Router,
+	r *metrics.Router,
 	client_ *elastic.Client,
 	analyticsClient_ *ga.Client,
 	ep_ *endpoints.Endpoints,
@@ -29,47 +33,164 @@ func Init(
 	ep = ep_
 	db = db_
 
-	r.GET("/v2/redirect/github/authorize", common.RedirectGithubAuthorize)
-	r.POST("/v2/auth/github", common.GithubAuth)
+	// login with github, gitlab or oidc
+	r.GET("/v2/redirect/:provider/authorize", common.RedirectAuthorize)
+	r.POST("/v2/auth/:provider", common.Login)
 
 	r.GET("/v2/query", q)
 
 	// authenticated endpoints
-	r.GET("/v2/user", access.MaybeAuth(db, common.GetUser))
+	r.GET("/v2/user", access.MaybeAuth(db, access.RequireScope(domain.ScopeRead, common.GetUser)))
+	// personal data
+	r.GET("/v2/user/export", access.IfAuth(db, access.RequireScope(domain.ScopeRead, common.ExportUser)))
+	r.DELETE("/v2/user",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, common.DeleteUser)))
+
+	// user directory
+	r.GET("/v2/users", access.MaybeAuth(db, access.RequireScope(domain.ScopeRead, common.SearchUsers)))
+	r.GET("/v2/users/:login",
+		access.MaybeAuth(db, access.RequireScope(domain.ScopeRead, common.GetUserProfile)))
+	r.GET("/v2/users/:login/snippets",
+		access.MaybeAuth(db, access.RequireScope(domain.ScopeRead, common.GetUserSnippets)))
+
+	// identities the user can log in with, linking an identity owned by
+	// another account merges both accounts
+	r.GET("/v2/user/identities",
+		access.IfAuth(db, access.RequireScope(domain.ScopeRead, common.ListIdentities)))
+	r.POST("/v2/user/identities/:provider",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, common.LinkIdentity)))
+	r.DELETE("/v2/user/identities/:provider/:id",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, common.UnlinkIdentity)))
+
+	// invitations to organizations
+	r.GET("/v2/user/invitations",
+		access.IfAuth(db, access.RequireScope(domain.ScopeRead, common.ListInvitations)))
+	r.POST("/v2/user/invitations/:id/accept",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, common.AcceptInvitation)))
+	r.POST("/v2/user/invitations/:id/decline",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, common.DeclineInvitation)))
+
+	// personal access tokens, for the cli and automation
+	r.POST("/v2/tokens",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, common.CreatePersonalAccessToken)))
+	r.GET("/v2/tokens",
+		access.IfAuth(db, access.RequireScope(domain.ScopeRead, common.ListPersonalAccessTokens)))
+	r.PUT("/v2/tokens/:id",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, common.RenamePersonalAccessToken)))
+	r.DELETE("/v2/tokens/:id",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, common.RevokeAccessToken)))
+
+	// any token can revoke itself
+	r.POST("/v2/logout", access.IfAuth(db, common.Logout))
+	r.POST("/v2/logout/all",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, common.LogoutEverywhere)))
 
 	// snippets
-	r.GET("/v2/p/:id/:owner", access.MaybeAuth(db, getSnippet))
-	r.GET("/v2/latest/:owner", access.IfAuth(db, getLatestSnippets))
-	r.POST("/v2/p", access.IfAuth(db, access.Control(createSnippet, access.Create)))
-	//r.DELETE("/v2/p/:id", access.IfAuth(deleteSnippet))
-	r.PUT("/v2/p", access.IfAuth(db, access.Control(updateSnippet, access.Update)))
-	r.POST("/v2/worked", access.IfAuth(db, snippetWorked))
+	r.GET("/v2/p/:id/:owner", access.MaybeAuth(db, access.RequireScope(domain.ScopeRead, getSnippet)))
+	r.GET("/v2/latest/:owner",
+		access.IfAuth(db, access.RequireScope(domain.ScopeRead, getLatestSnippets)))
+	r.POST("/v2/p",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, access.Control(createSnippet, access.Create))))
+	r.DELETE("/v2/p/:id/:owner",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, access.Control(deleteSnippet, access.Update))))
+	r.PUT("/v2/p",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, access.Control(updateSnippet, access.Update))))
+	r.POST("/v2/worked", access.IfAuth(db, access.RequireScope(domain.ScopeWrite, snippetWorked)))
 	r.POST("/v2/slack", common.SlackCommand)
 
 	// organizations
-	r.POST("/v2/organizations", access.IfAuth(db, common.CreateOrganization))
-	r.GET("/v2/organizations", access.IfAuth(db, common.ListUserOrganizations))
+	r.POST("/v2/organizations",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, common.CreateOrganization)))
+	r.GET("/v2/organizations",
+		access.IfAuth(db, access.RequireScope(domain.ScopeRead, common.ListUserOrganizations)))
+	r.GET("/v2/organizations/:id",
+		access.IfAuth(db, access.RequireScope(domain.ScopeRead, common.GetOrganization)))
+	r.GET("/v2/organizations/:id/members",
+		access.IfAuth(db, access.RequireScope(domain.ScopeRead, common.ListOrganizationMembers)))
+	r.GET("/v2/organizations/:id/audit",
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.ListAuditEvents)))
+	r.GET("/v2/organizations/:id/join-links",
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.ListOrganizationJoinLinks)))
+	r.PUT("/v2/organizations/:id",
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.RenameOrganization)))
+	// members synced from a github organization or team
+	r.PUT("/v2/organizations/:id/github",
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.LinkGithubOrganization)))
+	r.DELETE("/v2/organizations/:id/github",
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.UnlinkGithubOrganization)))
+	// only the owner deletes an organization,
+	// a deleted organization can be restored during the grace period
+	r.DELETE("/v2/organizations/:id",
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.DeleteOrganization)))
 
-	// not rest at all but who cares ?
-	r.POST("/v2/organizations/leave/:id", access.IfAuth(db, common.LeaveOrganization))
-	r.POST("/v2/organizations/expel/:oid/user/id/:uid",
-		access.IfAuth(db, common.ExpelUserFromOrganization))
-	r.POST("/v2/organizations/admins/:oid/user/id/:uid",
-		access.IfAuth(db, common.GrantAdminRightToUser))
+	// httprouter cannot have the older /v2/organizations/leave/:id next to
+	// /v2/organizations/:id/transfer, both shapes share the routes below
+	r.POST("/v2/organizations/:id/:action", organizationAction(
+		map[string]httpr.Handle{
+			// not rest at all but who cares ?
+			"leave": access.IfAuth(db, access.RequireScope(domain.ScopeWrite, common.LeaveOrganization)),
+		},
+		map[string]httpr.Handle{
+			"invite": access.IfAuth(db,
+				access.RequireScope(domain.ScopeOrgAdmin, common.InviteToOrganization)),
+			"transfer": access.IfAuth(db,
+				access.RequireScope(domain.ScopeOrgAdmin, common.TransferOrganization)),
+			"restore": access.IfAuth(db,
+				access.RequireScope(domain.ScopeOrgAdmin, common.RestoreOrganization)),
+		}))
+	r.POST("/v2/organizations/:id/:action/user/id/:uid", organizationAction(
+		map[string]httpr.Handle{
+			"expel": access.IfAuth(db,
+				access.RequireScope(domain.ScopeOrgAdmin, common.ExpelUserFromOrganization)),
+			"admins": access.IfAuth(db,
+				access.RequireScope(domain.ScopeOrgAdmin, common.GrantAdminRightToUser)),
+		},
+		nil))
+	r.PUT("/v2/organizations/:id/members/:uid/role",
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.ChangeMemberRole)))
 
 	// organizations-join-links
 	// this is only allowed for the organization admin
-	r.POST("/v2/organization-join-links", access.IfAuth(db, common.CreateOrganizationJoinLink))
+	r.POST("/v2/organization-join-links",
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.CreateOrganizationJoinLink)))
 	r.DELETE("/v2/organization-join-links/id/:id",
-		access.IfAuth(db, common.DeleteOrganizationJoinLink))
-	// get a join link for a specific organization
-	// this is allowed only by the organization admin in order to share it again, or delete it.
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.DeleteOrganizationJoinLink)))
+	// get the latest join link of a specific organization
+	// this is allowed only by the organization admin in order to share it again, or revoke it.
 	r.GET("/v2/organization-join-links/organizations/:id",
-		access.IfAuth(db, common.GetOrganizationJoinLinkByOrganizationId))
-	// get a join link from a join-link id.
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.GetOrganizationJoinLinkByOrganizationId)))
+	// get a join link from a join-link id, for the organization admins only
 	r.GET("/v2/organization-join-links/id/:id",
-		access.IfAuth(db, common.GetOrganizationJoinLink))
+		access.IfAuth(db, access.RequireScope(domain.ScopeOrgAdmin, common.GetOrganizationJoinLink)))
+	// anyone holding a join link can see where it leads before logging in
+	r.GET("/v2/join/:id", common.PreviewOrganizationJoinLink)
 	// accept join link
 	// not restful at all, but pretty to read
-	r.POST("/v2/join/:id", access.IfAuth(db, common.JoinOrganization))
+	r.POST("/v2/join/:id",
+		access.IfAuth(db, access.RequireScope(domain.ScopeWrite, common.JoinOrganization)))
+}
+
+// organizationAction serves /v2/organizations/<action>/:id with the handlers of before,
+// and /v2/organizations/:id/<action> with the others, the id is always given as id and oid
+func organizationAction(before map[string]httpr.Handle, after map[string]httpr.Handle) httpr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httpr.Params) {
+		first, second := p.ByName("id"), p.ByName("action")
+		if h, ok := before[first]; ok {
+			h(w, r, httpr.Params{
+				{Key: "id", Value: second},
+				{Key: "oid", Value: second},
+				{Key: "uid", Value: p.ByName("uid")},
+			})
+			return
+		}
+		if h, ok := after[second]; ok {
+			h(w, r, httpr.Params{
+				{Key: "id", Value: first},
+				{Key: "oid", Value: first},
+				{Key: "uid", Value: p.ByName("uid")},
+			})
+			return
+		}
+		http.NotFound(w, r)
+	}
 }